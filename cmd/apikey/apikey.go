@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	"github.com/jayanth-parthsarathy/notify/internal/common/config"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/util"
+)
+
+const usage = `Usage:
+  apikey create -name NAME -scopes SCOPE[,SCOPE...] [-sender SENDER]
+  apikey revoke ID
+  apikey list
+
+Scopes: %s
+The database is configured like the other binaries (DATABASE_URL, NOTIFY_CONFIG or -config).
+`
+
+func main() {
+	if len(os.Args) < 2 || !slices.Contains([]string{"create", "revoke", "list"}, os.Args[1]) {
+		exitUsage()
+	}
+	command, args := os.Args[1], os.Args[2:]
+	fs := flag.NewFlagSet("apikey "+command, flag.ExitOnError)
+	configFile := fs.String("config", "", "path to a YAML config file")
+	name := fs.String("name", "", "name of the client the key is issued to")
+	scopes := fs.String("scopes", auth.ScopeNotifySend, "comma-separated scopes")
+	sender := fs.String("sender", "", "sender identity stamped into published messages")
+	fs.Parse(args)
+
+	util.LoadEnv()
+	var cfg config.APIKeys
+	var loadArgs []string
+	if *configFile != "" {
+		loadArgs = []string{"-config", *configFile}
+	}
+	err := config.Load(&cfg, "apikey", loadArgs)
+	logs.FailOnError(err, "Invalid configuration")
+	db := util.ConnectToDB(cfg.Database.URL)
+	defer db.Close(context.Background())
+	store := auth.NewPgStore(db)
+	ctx := context.Background()
+
+	switch command {
+	case "create":
+		if *name == "" {
+			exitUsage()
+		}
+		key, token, err := auth.Issue(ctx, store, *name, strings.Split(*scopes, ","), *sender)
+		logs.FailOnError(err, "Failed to create API key")
+		fmt.Printf("id:     %s\nscopes: %s\nkey:    %s\n\nStore the key now, it cannot be shown again.\n", key.ID, strings.Join(key.Scopes, ","), token)
+	case "revoke":
+		if fs.NArg() != 1 {
+			exitUsage()
+		}
+		err := store.Revoke(ctx, fs.Arg(0))
+		logs.FailOnError(err, "Failed to revoke API key")
+		fmt.Printf("Revoked %s\n", fs.Arg(0))
+	case "list":
+		keys, err := store.List(ctx)
+		logs.FailOnError(err, "Failed to list API keys")
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(keys)
+	}
+}
+
+func exitUsage() {
+	fmt.Fprintf(os.Stderr, usage, strings.Join(auth.Scopes, ", "))
+	os.Exit(2)
+}
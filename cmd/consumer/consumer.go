@@ -2,13 +2,57 @@ package main
 
 import (
 	"context"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/jayanth-parthsarathy/notify/internal/common/config"
+	"github.com/jayanth-parthsarathy/notify/internal/common/health"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/templates"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
 	"github.com/jayanth-parthsarathy/notify/internal/common/util"
 	"github.com/jayanth-parthsarathy/notify/internal/consumer"
 	consumer_types "github.com/jayanth-parthsarathy/notify/internal/consumer/types"
 	"github.com/sirupsen/logrus"
 )
 
+func newRegistry(cfg config.Consumer) *consumer_types.Registry {
+	client := consumer_types.NewHTTPClient()
+	public := consumer_types.NewPublicHTTPClient()
+	registry := consumer_types.NewRegistry()
+	var sender consumer_types.EmailSender = &consumer_types.GmailSender{
+		Host:     cfg.SMTP.Host,
+		Port:     cfg.SMTP.Port,
+		From:     cfg.SMTP.From,
+		Password: cfg.SMTP.Password,
+	}
+	if cfg.SMTP.MaxPerSecond > 0 || cfg.SMTP.MaxPerDay > 0 {
+		sender = &consumer_types.ThrottledSender{
+			Sender:   sender,
+			Throttle: ratelimit.NewThrottle(cfg.SMTP.MaxPerSecond, cfg.SMTP.MaxPerDay),
+			MaxWait:  cfg.SMTP.MaxWait,
+		}
+	}
+	registry.Register(types.ChannelEmail, &consumer_types.EmailProvider{Sender: sender})
+	registry.Register(types.ChannelWebhook, &consumer_types.WebhookProvider{Client: public})
+	registry.Register(types.ChannelSlack, &consumer_types.SlackProvider{Client: public, WebhookURL: cfg.Slack.WebhookURL})
+	if cfg.SMS.GatewayURL != "" {
+		registry.Register(types.ChannelSMS, &consumer_types.SMSProvider{
+			Client:     client,
+			GatewayURL: cfg.SMS.GatewayURL,
+			Token:      cfg.SMS.Token,
+			From:       cfg.SMS.From,
+		})
+	}
+	return registry
+}
+
 func main() {
 	logrus.SetLevel(logrus.DebugLevel)
 	logrus.SetFormatter(&logrus.TextFormatter{
@@ -18,11 +62,34 @@ func main() {
 		PadLevelText:    true,
 	})
 	util.LoadEnv()
-	conn := util.ConnectToRabbitMQ()
-	defer conn.Close()
-	util.DeclareQueue(conn)
-	db := util.ConnectToDB()
-	defer db.Close(context.Background())
-	gmailSender := consumer_types.GmailSender{}
-	consumer.StartWorkers(conn, &gmailSender, db)
+	var cfg config.Consumer
+	err := config.Load(&cfg, os.Args[0], os.Args[1:])
+	logs.FailOnError(err, "Invalid configuration")
+	logrus.Infof("Effective configuration:\n%s", config.Redacted(cfg))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing, err := tracing.Setup(ctx, "notify-consumer", cfg.Tracing.Exporter, cfg.Tracing.Endpoint)
+	logs.FailOnError(err, "Failed to set up tracing")
+	defer func() {
+		logs.LogError(shutdownTracing(context.Background()), "Failed to flush traces")
+	}()
+	retryPolicy := cfg.Retry.Policy()
+	db := util.ConnectToDBPool(cfg.Database.URL)
+	defer db.Close()
+	conns := util.ConnectToRabbitMQ(cfg.RabbitMQ.URL, util.DeclareTopology(retryPolicy))
+	defer conns.Close()
+	c := consumer.NewConsumer(newRegistry(cfg), status.NewPgStore(db), templates.NewPgStore(db), retryPolicy, schedule.NewPgStore(db))
+	c.Workers = cfg.Workers
+	c.Prefetch = cfg.Prefetch
+	c.SchedulerInterval = cfg.SchedulerInterval
+	c.ConfirmTimeout = cfg.ConfirmTimeout
+	c.ShutdownTimeout = cfg.ShutdownTimeout
+	c.Addr = cfg.Addr
+	c.Health.Add("rabbitmq", health.AMQP(conns))
+	c.Health.Add("channel", health.AMQPChannel(conns))
+	c.Health.Add("postgres", health.Postgres(db))
+	c.Health.Add("smtp", health.SMTP(net.JoinHostPort(cfg.SMTP.Host, cfg.SMTP.Port)))
+	go c.Serve(ctx)
+	c.StartWorkers(ctx, conns, db)
+	logrus.Info("Consumer stopped")
 }
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	"github.com/jayanth-parthsarathy/notify/internal/common/config"
 	"github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
 	"github.com/jayanth-parthsarathy/notify/internal/common/util"
 	"github.com/jayanth-parthsarathy/notify/internal/dlqstore"
 	"github.com/jayanth-parthsarathy/notify/internal/dlqstore/types"
@@ -16,9 +25,30 @@ func main() {
 		TimestampFormat: "2006-01-02 15:04:05",
 		PadLevelText:    true,
 	})
-	conn := util.ConnectToRabbitMQ()
-	var connAdapter = dlqstore_types.NewConnectionAdapter(conn)
-	db := util.ConnectToDB()
-	inspector := dlqstore.NewPgInspector(db, connAdapter, constants.MainQueueName)
-	dlqstore.StartServer(conn, inspector, db)
+	util.LoadEnv()
+	var cfg config.DLQStore
+	err := config.Load(&cfg, os.Args[0], os.Args[1:])
+	logs.FailOnError(err, "Invalid configuration")
+	logrus.Infof("Effective configuration:\n%s", config.Redacted(cfg))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing, err := tracing.Setup(ctx, "notify-dlqstore", cfg.Tracing.Exporter, cfg.Tracing.Endpoint)
+	logs.FailOnError(err, "Failed to set up tracing")
+	defer func() {
+		logs.LogError(shutdownTracing(context.Background()), "Failed to flush traces")
+	}()
+	conns := util.ConnectToRabbitMQ(cfg.RabbitMQ.URL, nil)
+	var connAdapter = dlqstore_types.NewConnectionAdapter(conns)
+	db := util.ConnectToDBPool(cfg.Database.URL)
+	defer db.Close()
+	inspector := dlqstore.NewPgInspector(db, connAdapter, constants.MainQueueName, cfg.Retry.Policy())
+	inspector.ConfirmTimeout = cfg.ConfirmTimeout
+	var authn *auth.Authenticator
+	if cfg.Auth.Enabled {
+		authn = auth.NewAuthenticator(auth.NewPgStore(db))
+	} else {
+		logrus.Warn("API key authentication is disabled")
+	}
+	dlqstore.StartServer(ctx, cfg.Addr, conns, inspector, db, authn, cfg.ShutdownTimeout)
+	logrus.Info("DLQ store stopped")
 }
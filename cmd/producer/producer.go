@@ -1,8 +1,25 @@
 package main
 
 import (
+	"context"
+	"math"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	"github.com/jayanth-parthsarathy/notify/internal/common/config"
+	"github.com/jayanth-parthsarathy/notify/internal/common/health"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/templates"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
 	"github.com/jayanth-parthsarathy/notify/internal/common/util"
 	"github.com/jayanth-parthsarathy/notify/internal/producer"
+	producer_types "github.com/jayanth-parthsarathy/notify/internal/producer/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,8 +32,54 @@ func main() {
 		PadLevelText:    true,
 	})
 	util.LoadEnv()
-	conn := util.ConnectToRabbitMQ()
-	defer conn.Close()
-	util.DeclareQueue(conn)
-	producer.StartServer(conn)
+	var cfg config.Producer
+	err := config.Load(&cfg, os.Args[0], os.Args[1:])
+	logs.FailOnError(err, "Invalid configuration")
+	logrus.Infof("Effective configuration:\n%s", config.Redacted(cfg))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing, err := tracing.Setup(ctx, "notify-producer", cfg.Tracing.Exporter, cfg.Tracing.Endpoint)
+	logs.FailOnError(err, "Failed to set up tracing")
+	defer func() {
+		logs.LogError(shutdownTracing(context.Background()), "Failed to flush traces")
+	}()
+	conns := util.ConnectToRabbitMQ(cfg.RabbitMQ.URL, util.DeclareTopology(cfg.Retry.Policy()))
+	defer conns.Close()
+	var idempotencyStore producer_types.IdempotencyStore = producer.NewMemoryIdempotencyStore()
+	// Workers update statuses in Postgres, so without it there is nothing to
+	// report beyond "queued" and status tracking stays disabled.
+	var statusStore status.Store
+	var templateStore templates.Store
+	var scheduleStore schedule.Store
+	var db *pgxpool.Pool
+	if cfg.Database.URL != "" {
+		db = util.ConnectToDBPool(cfg.Database.URL)
+		defer db.Close()
+		statusStore = status.NewPgStore(db)
+		templateStore = templates.NewPgStore(db)
+		scheduleStore = schedule.NewPgStore(db)
+		if cfg.IdempotencyStore == "postgres" {
+			idempotencyStore = producer.NewPgIdempotencyStore(db)
+		}
+	}
+	server := producer.NewServer(conns, idempotencyStore, cfg.IdempotencyWindow, statusStore, templateStore, scheduleStore)
+	server.Addr = cfg.Addr
+	server.ConfirmTimeout = cfg.ConfirmTimeout
+	server.AllowedSenders = cfg.AllowedSenders
+	server.ChannelPoolSize = cfg.ChannelPoolSize
+	server.ChannelAcquireTimeout = cfg.ChannelAcquireTimeout
+	server.ShutdownTimeout = cfg.ShutdownTimeout
+	if db != nil {
+		server.Health.Add("postgres", health.Postgres(db))
+	}
+	server.ClientLimit = ratelimit.NewKeyed(cfg.RateLimit.ClientPerSecond, cfg.RateLimit.ClientBurst)
+	server.RecipientLimit = ratelimit.NewKeyed(cfg.RateLimit.RecipientPerMinute/60, int(math.Ceil(cfg.RateLimit.RecipientPerMinute)))
+	server.DomainLimit = ratelimit.NewKeyed(cfg.RateLimit.DomainPerMinute/60, int(math.Ceil(cfg.RateLimit.DomainPerMinute)))
+	if cfg.Auth.Enabled {
+		server.Auth = auth.NewAuthenticator(auth.NewPgStore(db))
+	} else {
+		logrus.Warn("API key authentication is disabled")
+	}
+	server.Start(ctx)
+	logrus.Info("Producer stopped")
 }
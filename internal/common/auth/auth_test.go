@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore struct {
+	keys map[string]Key
+	err  error
+}
+
+func (m *memoryStore) Lookup(_ context.Context, hash string) (Key, error) {
+	if m.err != nil {
+		return Key{}, m.err
+	}
+	key, ok := m.keys[hash]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (m *memoryStore) Create(_ context.Context, key Key, hash string) error {
+	if m.keys == nil {
+		m.keys = make(map[string]Key)
+	}
+	m.keys[hash] = key
+	return nil
+}
+
+func (m *memoryStore) Revoke(context.Context, string) error { return nil }
+
+func (m *memoryStore) List(context.Context) ([]Key, error) { return nil, nil }
+
+func TestIssue(t *testing.T) {
+	store := &memoryStore{}
+	key, token, err := Issue(context.Background(), store, "billing", []string{ScopeNotifySend}, "billing@example.com")
+
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, tokenPrefix))
+	assert.Equal(t, key, store.keys[Hash(token)])
+	assert.NotContains(t, store.keys, token, "only the hash is stored")
+
+	_, _, err = Issue(context.Background(), store, "billing", []string{"admin"}, "")
+	assert.ErrorContains(t, err, "unknown scope")
+	_, _, err = Issue(context.Background(), store, "billing", nil, "")
+	assert.Error(t, err)
+}
+
+func TestAuthenticator_Require(t *testing.T) {
+	store := &memoryStore{}
+	_, sendToken, err := Issue(context.Background(), store, "app", []string{ScopeNotifySend}, "app@example.com")
+	require.NoError(t, err)
+	_, readToken, err := Issue(context.Background(), store, "ops", []string{ScopeDLQRead}, "")
+	require.NoError(t, err)
+
+	var seen Key
+	handler := NewAuthenticator(store).Require(ScopeNotifySend, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen, _ = FromContext(req.Context())
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		code   int
+	}{
+		{"missing key", "", "", http.StatusUnauthorized},
+		{"unknown key", "Authorization", "Bearer nk_nope", http.StatusUnauthorized},
+		{"missing scope", "X-API-Key", readToken, http.StatusForbidden},
+		{"bearer token", "Authorization", "Bearer " + sendToken, http.StatusAccepted},
+		{"api key header", "X-API-Key", sendToken, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/notify", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code)
+		})
+	}
+	assert.Equal(t, "app@example.com", seen.Sender)
+}
+
+func TestAuthenticator_StoreError(t *testing.T) {
+	handler := NewAuthenticator(&memoryStore{err: errors.New("db down")}).Require(ScopeDLQRead, http.NotFoundHandler())
+	req := httptest.NewRequest(http.MethodGet, "/inspect", nil)
+	req.Header.Set("X-API-Key", "nk_key")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestAuthenticator_NilLeavesHandlerOpen(t *testing.T) {
+	var a *Authenticator
+	rec := httptest.NewRecorder()
+	a.Require(ScopeDLQRead, http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/inspect", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestStamp(t *testing.T) {
+	assert.Nil(t, Stamp(context.Background(), nil))
+	assert.Equal(t, amqp.Table{}, Stamp(WithKey(context.Background(), Key{}), amqp.Table{}))
+
+	headers := Stamp(WithKey(context.Background(), Key{Sender: "billing"}), amqp.Table{"traceparent": "00-abc"})
+	assert.Equal(t, amqp.Table{"traceparent": "00-abc", SenderHeader: "billing"}, headers)
+}
+
+func TestPgStore_Lookup(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	createdAt := time.Now().UTC()
+	mockDB.ExpectQuery(`SELECT id, name, scopes, sender, created_at FROM api_keys WHERE key_hash = \$1 AND revoked_at IS NULL`).
+		WithArgs(Hash("nk_good")).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "scopes", "sender", "created_at"}).
+			AddRow("key-1", "app", []string{ScopeNotifySend}, "app@example.com", createdAt))
+	mockDB.ExpectQuery(`SELECT id, name, scopes, sender, created_at FROM api_keys`).
+		WithArgs(Hash("nk_revoked")).
+		WillReturnError(pgx.ErrNoRows)
+
+	store := NewPgStore(mockDB)
+	key, err := store.Lookup(context.Background(), Hash("nk_good"))
+	require.NoError(t, err)
+	assert.Equal(t, Key{ID: "key-1", Name: "app", Scopes: []string{ScopeNotifySend}, Sender: "app@example.com", CreatedAt: createdAt}, key)
+
+	_, err = store.Lookup(context.Background(), Hash("nk_revoked"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_Revoke(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectExec(`UPDATE api_keys SET revoked_at = now\(\) WHERE id = \$1 AND revoked_at IS NULL`).
+		WithArgs("key-1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mockDB.ExpectExec(`UPDATE api_keys SET revoked_at`).
+		WithArgs("key-1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	store := NewPgStore(mockDB)
+	assert.NoError(t, store.Revoke(context.Background(), "key-1"))
+	assert.ErrorIs(t, store.Revoke(context.Background(), "key-1"), ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
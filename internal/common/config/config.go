@@ -0,0 +1,258 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/retry"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
+)
+
+type RabbitMQ struct {
+	URL string `yaml:"url" env:"RABBIT_MQ_URL" flag:"rabbitmq-url" secret:"true"`
+}
+
+type Database struct {
+	URL string `yaml:"url" env:"DATABASE_URL" flag:"database-url" secret:"true"`
+}
+
+type Retry struct {
+	MaxAttempts  int             `yaml:"max_attempts" env:"RETRY_MAX_ATTEMPTS" flag:"retry-max-attempts" default:"3"`
+	Delays       []time.Duration `yaml:"delays" env:"RETRY_DELAYS" flag:"retry-delays"`
+	InitialDelay time.Duration   `yaml:"initial_delay" env:"RETRY_INITIAL_DELAY" flag:"retry-initial-delay"`
+	Multiplier   float64         `yaml:"multiplier" env:"RETRY_MULTIPLIER" flag:"retry-multiplier"`
+	MaxDelay     time.Duration   `yaml:"max_delay" env:"RETRY_MAX_DELAY" flag:"retry-max-delay"`
+	Jitter       float64         `yaml:"jitter" env:"RETRY_JITTER" flag:"retry-jitter"`
+}
+
+func (r Retry) Policy() retry.Policy {
+	p := retry.Policy{
+		MaxAttempts:  r.MaxAttempts,
+		Delays:       r.Delays,
+		InitialDelay: r.InitialDelay,
+		Multiplier:   r.Multiplier,
+		MaxDelay:     r.MaxDelay,
+		Jitter:       r.Jitter,
+	}
+	if len(p.Delays) == 0 && p.InitialDelay == 0 {
+		p.Delays = retry.DefaultPolicy().Delays
+	}
+	if p.InitialDelay > 0 && p.Multiplier == 0 {
+		p.Multiplier = 2
+	}
+	return p
+}
+
+type SMTP struct {
+	Host     string `yaml:"host" env:"SMTPHOST" flag:"smtp-host"`
+	Port     string `yaml:"port" env:"SMTPPORT" flag:"smtp-port" default:"587"`
+	From     string `yaml:"from" env:"FROM_EMAIL" flag:"smtp-from"`
+	Password string `yaml:"password" env:"APP_PASSWORD" secret:"true"`
+	// Zero disables either limit. Gmail allows 500 recipients a day, 2000 on
+	// Workspace.
+	MaxPerSecond int           `yaml:"max_per_second" env:"SMTP_MAX_PER_SECOND" flag:"smtp-max-per-second" default:"2"`
+	MaxPerDay    int           `yaml:"max_per_day" env:"SMTP_MAX_PER_DAY" flag:"smtp-max-per-day" default:"0"`
+	MaxWait      time.Duration `yaml:"max_wait" env:"SMTP_THROTTLE_MAX_WAIT" flag:"smtp-throttle-max-wait" default:"30s"`
+}
+
+type Slack struct {
+	WebhookURL string `yaml:"webhook_url" env:"SLACK_WEBHOOK_URL" secret:"true"`
+}
+
+type SMS struct {
+	GatewayURL string `yaml:"gateway_url" env:"SMS_GATEWAY_URL" flag:"sms-gateway-url"`
+	Token      string `yaml:"token" env:"SMS_GATEWAY_TOKEN" secret:"true"`
+	From       string `yaml:"from" env:"SMS_FROM" flag:"sms-from"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" default:"none"`
+	Endpoint string `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" flag:"tracing-endpoint"`
+}
+
+func (t Tracing) Validate() error {
+	switch t.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+		return nil
+	}
+	return fmt.Errorf("tracing.exporter must be one of none, stdout or otlp, got %q", t.Exporter)
+}
+
+type Auth struct {
+	Enabled bool `yaml:"enabled" env:"AUTH_ENABLED" flag:"auth" default:"true"`
+}
+
+// RateLimit limits producer requests. Zero disables a limit.
+type RateLimit struct {
+	ClientPerSecond    float64 `yaml:"client_per_second" env:"RATE_LIMIT_CLIENT_PER_SECOND" flag:"rate-limit-client" default:"50"`
+	ClientBurst        int     `yaml:"client_burst" env:"RATE_LIMIT_CLIENT_BURST" flag:"rate-limit-client-burst" default:"100"`
+	RecipientPerMinute float64 `yaml:"recipient_per_minute" env:"RATE_LIMIT_RECIPIENT_PER_MINUTE" flag:"rate-limit-recipient" default:"10"`
+	DomainPerMinute    float64 `yaml:"domain_per_minute" env:"RATE_LIMIT_DOMAIN_PER_MINUTE" flag:"rate-limit-domain" default:"600"`
+}
+
+func (r RateLimit) Validate() error {
+	if r.ClientPerSecond < 0 || r.RecipientPerMinute < 0 || r.DomainPerMinute < 0 || r.ClientBurst < 0 {
+		return errors.New("rate_limit values must not be negative")
+	}
+	return nil
+}
+
+type Producer struct {
+	Addr                  string        `yaml:"addr" env:"PRODUCER_ADDR" flag:"addr" default:":8090"`
+	RabbitMQ              RabbitMQ      `yaml:"rabbitmq"`
+	Database              Database      `yaml:"database"`
+	Retry                 Retry         `yaml:"retry"`
+	Tracing               Tracing       `yaml:"tracing"`
+	Auth                  Auth          `yaml:"auth"`
+	RateLimit             RateLimit     `yaml:"rate_limit"`
+	IdempotencyStore      string        `yaml:"idempotency_store" env:"IDEMPOTENCY_STORE" flag:"idempotency-store" default:"memory"`
+	IdempotencyWindow     time.Duration `yaml:"idempotency_window" env:"IDEMPOTENCY_WINDOW" flag:"idempotency-window" default:"24h"`
+	AllowedSenders        []string      `yaml:"allowed_senders" env:"ALLOWED_SENDERS" flag:"allowed-senders"`
+	ConfirmTimeout        time.Duration `yaml:"confirm_timeout" env:"PUBLISH_CONFIRM_TIMEOUT" flag:"confirm-timeout" default:"5s"`
+	ChannelPoolSize       int           `yaml:"channel_pool_size" env:"CHANNEL_POOL_SIZE" flag:"channel-pool-size" default:"16"`
+	ChannelAcquireTimeout time.Duration `yaml:"channel_acquire_timeout" env:"CHANNEL_ACQUIRE_TIMEOUT" flag:"channel-acquire-timeout" default:"1s"`
+	ShutdownTimeout       time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"25s"`
+}
+
+func (c *Producer) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if c.RabbitMQ.URL == "" {
+		return errors.New("rabbitmq.url is required")
+	}
+	if c.IdempotencyStore != "memory" && c.IdempotencyStore != "postgres" {
+		return fmt.Errorf("idempotency_store must be \"memory\" or \"postgres\", got %q", c.IdempotencyStore)
+	}
+	if c.IdempotencyStore == "postgres" && c.Database.URL == "" {
+		return errors.New("database.url is required when idempotency_store is \"postgres\"")
+	}
+	if c.Auth.Enabled && c.Database.URL == "" {
+		return errors.New("database.url is required when auth is enabled")
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+	if c.ChannelPoolSize < 1 {
+		return errors.New("channel_pool_size must be at least 1")
+	}
+	if err := positive(map[string]time.Duration{
+		"idempotency_window":      c.IdempotencyWindow,
+		"confirm_timeout":         c.ConfirmTimeout,
+		"channel_acquire_timeout": c.ChannelAcquireTimeout,
+		"shutdown_timeout":        c.ShutdownTimeout,
+	}); err != nil {
+		return err
+	}
+	return c.Retry.Policy().Validate()
+}
+
+type Consumer struct {
+	Addr              string        `yaml:"addr" env:"CONSUMER_ADDR" flag:"addr" default:":8092"`
+	RabbitMQ          RabbitMQ      `yaml:"rabbitmq"`
+	Database          Database      `yaml:"database"`
+	Retry             Retry         `yaml:"retry"`
+	Tracing           Tracing       `yaml:"tracing"`
+	SMTP              SMTP          `yaml:"smtp"`
+	Slack             Slack         `yaml:"slack"`
+	SMS               SMS           `yaml:"sms"`
+	Workers           int           `yaml:"workers" env:"CONSUMER_WORKERS" flag:"workers" default:"5"`
+	Prefetch          int           `yaml:"prefetch" env:"CONSUMER_PREFETCH" flag:"prefetch" default:"1"`
+	SchedulerInterval time.Duration `yaml:"scheduler_interval" env:"SCHEDULER_INTERVAL" flag:"scheduler-interval" default:"1s"`
+	ConfirmTimeout    time.Duration `yaml:"confirm_timeout" env:"PUBLISH_CONFIRM_TIMEOUT" flag:"confirm-timeout" default:"5s"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"25s"`
+}
+
+func (c *Consumer) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if c.RabbitMQ.URL == "" {
+		return errors.New("rabbitmq.url is required")
+	}
+	if c.Database.URL == "" {
+		return errors.New("database.url is required")
+	}
+	if c.SMTP.Host == "" || c.SMTP.From == "" {
+		return errors.New("smtp.host and smtp.from are required")
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if c.SMTP.MaxPerSecond < 0 || c.SMTP.MaxPerDay < 0 {
+		return errors.New("smtp.max_per_second and smtp.max_per_day must not be negative")
+	}
+	if c.Workers < 1 {
+		return errors.New("workers must be at least 1")
+	}
+	if c.Prefetch < 1 {
+		return errors.New("prefetch must be at least 1")
+	}
+	if err := positive(map[string]time.Duration{
+		"scheduler_interval": c.SchedulerInterval,
+		"confirm_timeout":    c.ConfirmTimeout,
+		"smtp.max_wait":      c.SMTP.MaxWait,
+		"shutdown_timeout":   c.ShutdownTimeout,
+	}); err != nil {
+		return err
+	}
+	return c.Retry.Policy().Validate()
+}
+
+type DLQStore struct {
+	Addr            string        `yaml:"addr" env:"DLQSTORE_ADDR" flag:"addr" default:":8091"`
+	RabbitMQ        RabbitMQ      `yaml:"rabbitmq"`
+	Database        Database      `yaml:"database"`
+	Retry           Retry         `yaml:"retry"`
+	Tracing         Tracing       `yaml:"tracing"`
+	Auth            Auth          `yaml:"auth"`
+	ConfirmTimeout  time.Duration `yaml:"confirm_timeout" env:"PUBLISH_CONFIRM_TIMEOUT" flag:"confirm-timeout" default:"5s"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"25s"`
+}
+
+func (c *DLQStore) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if c.RabbitMQ.URL == "" {
+		return errors.New("rabbitmq.url is required")
+	}
+	if c.Database.URL == "" {
+		return errors.New("database.url is required")
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if err := positive(map[string]time.Duration{
+		"confirm_timeout":  c.ConfirmTimeout,
+		"shutdown_timeout": c.ShutdownTimeout,
+	}); err != nil {
+		return err
+	}
+	return c.Retry.Policy().Validate()
+}
+
+// APIKeys configures the apikey admin CLI.
+type APIKeys struct {
+	Database Database `yaml:"database"`
+}
+
+func (c *APIKeys) Validate() error {
+	if c.Database.URL == "" {
+		return errors.New("database.url is required")
+	}
+	return nil
+}
+
+func positive(durations map[string]time.Duration) error {
+	for name, d := range durations {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	return nil
+}
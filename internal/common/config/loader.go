@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const FileEnv = "NOTIFY_CONFIG"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type field struct {
+	value reflect.Value
+	tag   reflect.StructTag
+	path  string
+}
+
+// Load fills cfg from its `default` tags, then a YAML file (-config or
+// $NOTIFY_CONFIG), then `env` tags, then `flag` tags, each source overriding
+// the previous one, and finally validates it.
+func Load(cfg any, name string, args []string) error {
+	fields := collect(reflect.ValueOf(cfg).Elem(), "")
+	for _, f := range fields {
+		if def, ok := f.tag.Lookup("default"); ok {
+			if err := set(f.value, def); err != nil {
+				return fmt.Errorf("%s: invalid default: %w", f.path, err)
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := fs.String("config", os.Getenv(FileEnv), "path to a YAML config file")
+	flagValues := make(map[string]*string)
+	for _, f := range fields {
+		if name := f.tag.Get("flag"); name != "" {
+			usage := f.path
+			if env := f.tag.Get("env"); env != "" {
+				usage += " (env " + env + ")"
+			}
+			flagValues[name] = fs.String(name, "", usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file != "" {
+		if err := loadFile(cfg, *file); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range fields {
+		env := f.tag.Get("env")
+		if env == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(env); ok && raw != "" {
+			if err := set(f.value, raw); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+
+	visited := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) { visited[fl.Name] = true })
+	for _, f := range fields {
+		name := f.tag.Get("flag")
+		if !visited[name] {
+			continue
+		}
+		if err := set(f.value, *flagValues[name]); err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+
+	if v, ok := cfg.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+func loadFile(cfg any, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func collect(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		path := yamlName(sf)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		fv := v.Field(i)
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, collect(fv, path)...)
+			continue
+		}
+		fields = append(fields, field{value: fv, tag: sf.Tag, path: path})
+	}
+	return fields
+}
+
+func yamlName(sf reflect.StructField) string {
+	name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(sf.Name)
+	}
+	return name
+}
+
+func set(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		parts := strings.Split(raw, ",")
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := set(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// Redacted renders cfg as YAML with every `secret` field masked. Secret URLs
+// keep their scheme and host so they stay useful for debugging.
+func Redacted(cfg any) string {
+	out, err := yaml.Marshal(redact(reflect.ValueOf(cfg)))
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+func redact(v reflect.Value) any {
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		switch {
+		case sf.Type.Kind() == reflect.Struct:
+			out[yamlName(sf)] = redact(fv)
+		case sf.Tag.Get("secret") == "true":
+			out[yamlName(sf)] = mask(fv.String())
+		case sf.Type == durationType:
+			out[yamlName(sf)] = time.Duration(fv.Int()).String()
+		case sf.Type.Kind() == reflect.Slice && sf.Type.Elem() == durationType:
+			parts := make([]string, fv.Len())
+			for j := range parts {
+				parts[j] = time.Duration(fv.Index(j).Int()).String()
+			}
+			out[yamlName(sf)] = parts
+		default:
+			out[yamlName(sf)] = fv.Interface()
+		}
+	}
+	return out
+}
+
+func mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "****"
+	}
+	u.RawQuery = ""
+	if _, hasPassword := u.User.Password(); hasPassword {
+		return u.Redacted()
+	}
+	return u.Scheme + "://" + u.Host + "/****"
+}
@@ -0,0 +1,76 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrUnroutable     = errors.New("message could not be routed to a queue")
+	ErrNacked         = errors.New("broker rejected the message")
+	ErrConfirmTimeout = errors.New("timed out waiting for broker confirmation")
+)
+
+// ConfirmChannel publishes in confirm mode and waits up to Timeout for the
+// broker to ack each message. Any failed publish closes the underlying
+// channel: a confirm or return that arrives late would otherwise sit in the
+// notify buffers and block the connection's reader once they fill up.
+type ConfirmChannel struct {
+	Ch      *amqp.Channel
+	Timeout time.Duration
+	returns chan amqp.Return
+}
+
+func NewConfirmChannel(ch *amqp.Channel, timeout time.Duration) (*ConfirmChannel, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+	return &ConfirmChannel{Ch: ch, Timeout: timeout, returns: ch.NotifyReturn(make(chan amqp.Return, 1))}, nil
+}
+
+// The broker sends basic.return before the ack for an unroutable mandatory
+// message, so once the confirm arrives any matching return is already queued.
+func (c *ConfirmChannel) PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) (err error) {
+	defer func() {
+		if err != nil {
+			c.Ch.Close()
+		}
+	}()
+	confirm, err := c.Ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		return err
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(waitCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrConfirmTimeout
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case r := <-c.returns:
+			if r.MessageId == msg.MessageId {
+				return ErrUnroutable
+			}
+		default:
+			if !acked {
+				return ErrNacked
+			}
+			return nil
+		}
+	}
+}
+
+func (c *ConfirmChannel) Close() error {
+	return c.Ch.Close()
+}
+
+func (c *ConfirmChannel) IsClosed() bool {
+	return c.Ch.IsClosed()
+}
@@ -0,0 +1,182 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateClosed       = "closed"
+)
+
+var (
+	ErrClosed       = errors.New("connection manager is closed")
+	ErrNotConnected = errors.New("not connected to RabbitMQ")
+)
+
+type Manager struct {
+	URL        string
+	Setup      func(*amqp.Connection) error
+	Dial       func(url string) (*amqp.Connection, error)
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	state     string
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewManager(url string, setup func(*amqp.Connection) error) *Manager {
+	return &Manager{
+		URL:        url,
+		Setup:      setup,
+		Dial:       amqp.Dial,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		state:      StateConnecting,
+		ready:      make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (m *Manager) Start() {
+	go m.run()
+}
+
+func (m *Manager) run() {
+	attempt := 0
+	for {
+		conn, err := m.connect()
+		if err != nil {
+			logs.LogError(err, "Failed to connect to RabbitMQ")
+			delay := m.Backoff(attempt)
+			attempt++
+			log.Warnf("Retrying RabbitMQ connection in %s", delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-m.done:
+				return
+			}
+		}
+		attempt = 0
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		m.setConnection(conn)
+		log.Info("Connected to RabbitMQ")
+		select {
+		case amqpErr := <-closed:
+			log.Warnf("RabbitMQ connection lost: %v", amqpErr)
+			m.setConnection(nil)
+		case <-m.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (m *Manager) connect() (*amqp.Connection, error) {
+	conn, err := m.Dial(m.URL)
+	if err != nil {
+		return nil, err
+	}
+	if m.Setup != nil {
+		if err := m.Setup(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (m *Manager) Backoff(attempt int) time.Duration {
+	delay := m.MinBackoff
+	for i := 0; i < attempt && delay < m.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > m.MaxBackoff {
+		return m.MaxBackoff
+	}
+	return delay
+}
+
+func (m *Manager) setConnection(conn *amqp.Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == StateClosed {
+		return
+	}
+	m.conn = conn
+	if conn != nil {
+		m.state = StateConnected
+		close(m.ready)
+		return
+	}
+	m.state = StateReconnecting
+	m.ready = make(chan struct{})
+}
+
+// Connection blocks until a connection is available, the context is done or
+// the manager is closed.
+func (m *Manager) Connection(ctx context.Context) (*amqp.Connection, error) {
+	m.mu.RLock()
+	conn, ready, state := m.conn, m.ready, m.state
+	m.mu.RUnlock()
+	if state == StateClosed {
+		return nil, ErrClosed
+	}
+	if conn != nil {
+		return conn, nil
+	}
+	select {
+	case <-ready:
+		return m.Connection(ctx)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-m.done:
+		return nil, ErrClosed
+	}
+}
+
+func (m *Manager) Current() (*amqp.Connection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.state == StateClosed {
+		return nil, ErrClosed
+	}
+	if m.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return m.conn, nil
+}
+
+func (m *Manager) State() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		conn := m.conn
+		m.state = StateClosed
+		m.conn = nil
+		m.mu.Unlock()
+		close(m.done)
+		if conn != nil {
+			conn.Close()
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_Backoff(t *testing.T) {
+	m := NewManager("", nil)
+	m.MinBackoff = time.Second
+	m.MaxBackoff = 10 * time.Second
+
+	assert.Equal(t, time.Second, m.Backoff(0))
+	assert.Equal(t, 2*time.Second, m.Backoff(1))
+	assert.Equal(t, 8*time.Second, m.Backoff(3))
+	assert.Equal(t, 10*time.Second, m.Backoff(4))
+	assert.Equal(t, 10*time.Second, m.Backoff(100))
+}
+
+func TestManager_RetriesDialUntilClosed(t *testing.T) {
+	var attempts int32
+	m := NewManager("amqp://unreachable", nil)
+	m.MinBackoff = time.Millisecond
+	m.MaxBackoff = time.Millisecond
+	m.Dial = func(string) (*amqp.Connection, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errors.New("connection refused")
+	}
+	m.Start()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&attempts) >= 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, StateConnecting, m.State())
+	_, err := m.Current()
+	assert.ErrorIs(t, err, ErrNotConnected)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = m.Connection(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	m.Close()
+	assert.Equal(t, StateClosed, m.State())
+	_, err = m.Connection(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+}
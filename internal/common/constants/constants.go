@@ -4,7 +4,21 @@ const (
 	RetryExchangeName = "retry_exchange"
 	DLQName           = "dlq"
 	MainQueueName     = "notification"
-	Retry10sQueue     = "retry-10s"
-	Retry30sQueue     = "retry-30s"
-	Retry60sQueue     = "retry-60s"
+)
+
+// Headers the consumer records on failed messages.
+const (
+	ErrorClassHeader    = "x-error-class"
+	LastErrorHeader     = "x-last-error"
+	AttemptsHeader      = "x-attempts"
+	OriginalQueueHeader = "x-original-queue"
+)
+
+const (
+	MaxBatchSize            = 1000
+	MaxIdempotencyKeyLength = 255
+	// MaxRequestBytes leaves room for the base64 encoding of the largest
+	// attachments a single notification may carry.
+	MaxRequestBytes      = 16 << 20
+	MaxBatchRequestBytes = 64 << 20
 )
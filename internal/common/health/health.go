@@ -0,0 +1,150 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
+)
+
+type Check func(ctx context.Context) error
+
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type named struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks of a binary. Liveness only reports that
+// the process is serving HTTP, so a dependency outage never gets it restarted.
+type Checker struct {
+	Timeout time.Duration
+	mu      sync.RWMutex
+	checks  []named
+}
+
+func NewChecker() *Checker {
+	return &Checker{Timeout: 2 * time.Second}
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, named{name: name, check: check})
+}
+
+// Ready runs every check concurrently and reports each result by name.
+func (c *Checker) Ready(ctx context.Context) (bool, map[string]string) {
+	c.mu.RLock()
+	checks := append([]named(nil), c.checks...)
+	c.mu.RUnlock()
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, n := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = n.check(ctx)
+		}()
+	}
+	wg.Wait()
+	ready := true
+	results := make(map[string]string, len(checks))
+	for i, n := range checks {
+		results[n.name] = "ok"
+		if errs[i] != nil {
+			ready = false
+			results[n.name] = errs[i].Error()
+		}
+	}
+	return ready, results
+}
+
+func (c *Checker) handleLive(w http.ResponseWriter, _ *http.Request) {
+	writeResponse(w, http.StatusOK, Response{Status: "ok"})
+}
+
+func (c *Checker) handleReady(w http.ResponseWriter, req *http.Request) {
+	ready, results := c.Ready(req.Context())
+	if !ready {
+		writeResponse(w, http.StatusServiceUnavailable, Response{Status: "unavailable", Checks: results})
+		return
+	}
+	writeResponse(w, http.StatusOK, Response{Status: "ready", Checks: results})
+}
+
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", c.handleLive)
+	mux.HandleFunc("GET /readyz", c.handleReady)
+}
+
+func writeResponse(w http.ResponseWriter, code int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// AMQP fails while the connection manager is connecting or reconnecting.
+func AMQP(conns *connection.Manager) Check {
+	return func(context.Context) error {
+		_, err := conns.Current()
+		return err
+	}
+}
+
+// AMQPChannel opens and closes a channel, which fails when the broker has hit
+// its channel limit or is blocking the connection.
+func AMQPChannel(conns *connection.Manager) Check {
+	return func(context.Context) error {
+		conn, err := conns.Current()
+		if err != nil {
+			return err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			return err
+		}
+		return ch.Close()
+	}
+}
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func Postgres(db Pinger) Check {
+	return db.Ping
+}
+
+// SMTP dials the server and exchanges greeting, EHLO and QUIT with it.
+func SMTP(addr string) Check {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		client, err := smtp.NewClient(conn, host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		return client.Quit()
+	}
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(c *Checker, path string) (int, Response) {
+	mux := http.NewServeMux()
+	c.Register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var resp Response
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return rec.Code, resp
+}
+
+func TestChecker_Ready(t *testing.T) {
+	c := NewChecker()
+	c.Add("rabbitmq", func(context.Context) error { return nil })
+	c.Add("postgres", func(context.Context) error { return nil })
+
+	code, resp := serve(c, "/readyz")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Response{Status: "ready", Checks: map[string]string{"rabbitmq": "ok", "postgres": "ok"}}, resp)
+}
+
+func TestChecker_NotReady(t *testing.T) {
+	c := NewChecker()
+	c.Add("rabbitmq", AMQP(connection.NewManager("amqp://unreachable", nil)))
+	c.Add("postgres", func(context.Context) error { return errors.New("connection refused") })
+
+	code, resp := serve(c, "/readyz")
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", resp.Status)
+	assert.Equal(t, connection.ErrNotConnected.Error(), resp.Checks["rabbitmq"])
+	assert.Equal(t, "connection refused", resp.Checks["postgres"])
+}
+
+func TestChecker_LiveIgnoresDependencies(t *testing.T) {
+	c := NewChecker()
+	c.Add("postgres", func(context.Context) error { return errors.New("down") })
+
+	code, resp := serve(c, "/healthz")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+}
+
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "QUIT") {
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			}
+			conn.Write([]byte("250 mail.example.com\r\n"))
+		}
+	}()
+
+	assert.NoError(t, SMTP(ln.Addr().String())(context.Background()))
+
+	addr := ln.Addr().String()
+	ln.Close()
+	assert.Error(t, SMTP(addr)(context.Background()))
+}
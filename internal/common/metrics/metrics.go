@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "notify"
+
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by handler and status code.",
+	}, []string{"handler", "code"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	PublishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "publish_duration_seconds",
+		Help:      "Time to publish a notification and receive the broker confirm, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_messages_total",
+		Help:      "Messages handled by the consumer, by outcome (processed, acked, nacked, retried, rate_limited) and delivery attempt.",
+	}, []string{"outcome", "attempt"})
+
+	Retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_retries_total",
+		Help:      "Messages parked for a retry, by retry queue.",
+	}, []string{"queue"})
+
+	SendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_send_duration_seconds",
+		Help:      "Provider send latency, by provider and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "result"})
+
+	ActiveWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_active_workers",
+		Help:      "Workers currently consuming from a queue.",
+	})
+
+	InFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_inflight_messages",
+		Help:      "Messages currently being processed.",
+	})
+
+	DLQInserts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dlq_inserts_total",
+		Help:      "Dead-lettered messages stored by the DLQ worker, by result.",
+	}, []string{"result"})
+
+	DLQRequeues = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dlq_requeues_total",
+		Help:      "Dead-lettered messages requeued through the inspector, by result.",
+	}, []string{"result"})
+)
+
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+func Since(h *prometheus.HistogramVec, start time.Time, labels ...string) {
+	h.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+// InstrumentHandler records the request count and latency of next under name.
+func InstrumentHandler(name string, next http.Handler) http.Handler {
+	counter := HTTPRequests.MustCurryWith(prometheus.Labels{"handler": name})
+	duration := HTTPRequestDuration.MustCurryWith(prometheus.Labels{"handler": name})
+	return promhttp.InstrumentHandlerCounter(counter, promhttp.InstrumentHandlerDuration(duration, next))
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills b for the time since its last use and takes one token, or
+// reports how long until one is available.
+func (b *bucket) take(rate float64, burst int, now time.Time) time.Duration {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// Keyed keeps one token bucket per key, refilled at Rate tokens per second up
+// to Burst. A nil Keyed allows everything.
+type Keyed struct {
+	Rate  float64
+	Burst int
+	Now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+// NewKeyed returns nil, i.e. no limit, when rate is not positive.
+func NewKeyed(rate float64, burst int) *Keyed {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &Keyed{Rate: rate, Burst: burst, Now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+func (k *Keyed) Allow(key string) (bool, time.Duration) {
+	if k == nil {
+		return true, 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.Now()
+	k.sweep(now)
+	b, ok := k.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(k.Burst), last: now}
+		k.buckets[key] = b
+	}
+	wait := b.take(k.Rate, k.Burst, now)
+	return wait == 0, wait
+}
+
+// sweep drops buckets that have refilled completely, which behave exactly
+// like a new bucket, so memory stays bounded by the recently active keys.
+func (k *Keyed) sweep(now time.Time) {
+	if now.Sub(k.sweptAt) < sweepInterval {
+		return
+	}
+	k.sweptAt = now
+	full := time.Duration(float64(k.Burst) / k.Rate * float64(time.Second))
+	for key, b := range k.buckets {
+		if now.Sub(b.last) >= full {
+			delete(k.buckets, key)
+		}
+	}
+}
+
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("rate limit reached, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// Throttle paces outbound sends to PerSecond, and to PerDay within any
+// rolling 24 hours. Zero disables either limit.
+type Throttle struct {
+	PerSecond int
+	PerDay    int
+	Now       func() time.Time
+
+	mu     sync.Mutex
+	second bucket
+	day    []time.Time
+}
+
+func NewThrottle(perSecond int, perDay int) *Throttle {
+	return &Throttle{PerSecond: perSecond, PerDay: perDay, Now: time.Now, second: bucket{tokens: float64(perSecond), last: time.Now()}}
+}
+
+// reserve takes one per-second token and n per-day slots, or reports how long
+// until both are available without taking either.
+func (t *Throttle) reserve(n int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.Now()
+	var wait time.Duration
+	if t.PerDay > 0 {
+		n = min(n, t.PerDay)
+		cutoff := now.Add(-24 * time.Hour)
+		for len(t.day) > 0 && !t.day[0].After(cutoff) {
+			t.day = t.day[1:]
+		}
+		if over := len(t.day) + n - t.PerDay; over > 0 {
+			wait = t.day[over-1].Sub(cutoff)
+		}
+	}
+	if t.PerSecond > 0 {
+		next := t.second
+		if w := next.take(float64(t.PerSecond), t.PerSecond, now); w > 0 || wait > 0 {
+			return max(w, wait)
+		}
+		t.second = next
+	}
+	if wait > 0 {
+		return wait
+	}
+	for i := 0; t.PerDay > 0 && i < n; i++ {
+		t.day = append(t.day, now)
+	}
+	return 0
+}
+
+// Wait blocks until a send of n recipients is allowed. It returns a
+// *LimitedError right away when that would take longer than ctx allows.
+func (t *Throttle) Wait(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		wait := t.reserve(n)
+		if wait == 0 {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return &LimitedError{RetryAfter: wait}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return &LimitedError{RetryAfter: wait}
+		}
+	}
+}
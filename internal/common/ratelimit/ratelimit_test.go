@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestKeyed_BurstAndRefill(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	k := NewKeyed(2, 3)
+	k.Now = c.Now
+
+	for i := 0; i < 3; i++ {
+		ok, _ := k.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, wait := k.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = k.Allow("b")
+	assert.True(t, ok, "keys have separate buckets")
+
+	c.Advance(500 * time.Millisecond)
+	ok, _ = k.Allow("a")
+	assert.True(t, ok)
+}
+
+func TestKeyed_SweepsFullBuckets(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	k := NewKeyed(1, 1)
+	k.Now = c.Now
+	k.Allow("a")
+	c.Advance(2 * sweepInterval)
+	k.Allow("b")
+
+	assert.NotContains(t, k.buckets, "a")
+	assert.Contains(t, k.buckets, "b")
+}
+
+func TestKeyed_NilAllowsEverything(t *testing.T) {
+	k := NewKeyed(0, 10)
+	assert.Nil(t, k)
+	ok, wait := k.Allow("a")
+	assert.True(t, ok)
+	assert.Zero(t, wait)
+}
+
+func TestThrottle_PerSecond(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	th := NewThrottle(2, 0)
+	th.Now = c.Now
+	th.second.last = c.now
+
+	assert.Zero(t, th.reserve(1))
+	assert.Zero(t, th.reserve(1))
+	assert.Equal(t, 500*time.Millisecond, th.reserve(1))
+	c.Advance(500 * time.Millisecond)
+	assert.Zero(t, th.reserve(1))
+}
+
+func TestThrottle_RollingDay(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	th := NewThrottle(0, 5)
+	th.Now = c.Now
+
+	assert.Zero(t, th.reserve(3))
+	c.Advance(time.Hour)
+	assert.Zero(t, th.reserve(2))
+	assert.Equal(t, 23*time.Hour, th.reserve(1), "waits for the first send to leave the window")
+	assert.Equal(t, 24*time.Hour, th.reserve(4), "4 slots need the second send to expire too")
+
+	c.Advance(23 * time.Hour)
+	assert.Zero(t, th.reserve(3))
+	assert.Equal(t, time.Hour, th.reserve(1))
+}
+
+func TestThrottle_WaitGivesUpPastDeadline(t *testing.T) {
+	th := NewThrottle(0, 1)
+	assert.NoError(t, th.Wait(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := th.Wait(ctx, 1)
+
+	var limited *LimitedError
+	assert.True(t, errors.As(err, &limited))
+	assert.Greater(t, limited.RetryAfter, 23*time.Hour)
+}
+
+func TestThrottle_WaitBlocksForPerSecond(t *testing.T) {
+	th := NewThrottle(20, 0)
+	start := time.Now()
+	for i := 0; i < 21; i++ {
+		assert.NoError(t, th.Wait(context.Background(), 1))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
@@ -0,0 +1,138 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+type Policy struct {
+	MaxAttempts  int
+	Delays       []time.Duration
+	InitialDelay time.Duration
+	Multiplier   float64
+	MaxDelay     time.Duration
+	Jitter       float64
+}
+
+type Queue struct {
+	Name  string
+	Delay time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: 3,
+		Delays:      []time.Duration{10 * time.Second, 30 * time.Second, 60 * time.Second},
+	}
+}
+
+func (p Policy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return errors.New("retry policy: max attempts must be at least 1")
+	}
+	if p.Jitter < 0 || p.Jitter >= 1 {
+		return errors.New("retry policy: jitter must be in [0, 1)")
+	}
+	if p.MaxAttempts == 1 {
+		return nil
+	}
+	if len(p.Delays) > 0 {
+		for _, d := range p.Delays {
+			if d <= 0 {
+				return errors.New("retry policy: delays must be positive")
+			}
+		}
+		return nil
+	}
+	if p.InitialDelay <= 0 {
+		return errors.New("retry policy: either delays or an initial delay is required")
+	}
+	if p.Multiplier < 1 {
+		return errors.New("retry policy: multiplier must be at least 1")
+	}
+	if p.MaxDelay != 0 && p.MaxDelay < p.InitialDelay {
+		return errors.New("retry policy: max delay must not be below the initial delay")
+	}
+	return nil
+}
+
+func (p Policy) Delay(retryCount int) time.Duration {
+	if retryCount < 1 {
+		return 0
+	}
+	if len(p.Delays) > 0 {
+		if retryCount > len(p.Delays) {
+			return p.Delays[len(p.Delays)-1]
+		}
+		return p.Delays[retryCount-1]
+	}
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(retryCount-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func (p Policy) Exhausted(retryCount int) bool {
+	return retryCount >= p.MaxAttempts
+}
+
+func (p Policy) QueueName(retryCount int) string {
+	if retryCount < 1 || p.Exhausted(retryCount) {
+		return ""
+	}
+	return QueueNameForDelay(p.Delay(retryCount))
+}
+
+func (p Policy) Queues() []Queue {
+	var queues []Queue
+	seen := make(map[string]bool)
+	for retryCount := 1; retryCount < p.MaxAttempts; retryCount++ {
+		delay := p.Delay(retryCount)
+		name := QueueNameForDelay(delay)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		queues = append(queues, Queue{Name: name, Delay: delay})
+	}
+	return queues
+}
+
+// QueueFor returns the shortest retry queue that holds a message for at least
+// wait, or the longest queue when none does. It reports false when the policy
+// has no retry queues.
+func (p Policy) QueueFor(wait time.Duration) (Queue, bool) {
+	var fit, longest Queue
+	for _, q := range p.Queues() {
+		if q.Delay >= wait && (fit.Name == "" || q.Delay < fit.Delay) {
+			fit = q
+		}
+		if q.Delay > longest.Delay {
+			longest = q
+		}
+	}
+	if fit.Name != "" {
+		return fit, true
+	}
+	return longest, longest.Name != ""
+}
+
+func (p Policy) Expiration(retryCount int) string {
+	if p.Jitter == 0 {
+		return ""
+	}
+	delay := float64(p.Delay(retryCount)) * (1 - p.Jitter*rand.Float64())
+	return strconv.FormatInt(time.Duration(delay).Milliseconds(), 10)
+}
+
+func QueueNameForDelay(delay time.Duration) string {
+	if delay%time.Second == 0 {
+		return fmt.Sprintf("retry-%ds", delay/time.Second)
+	}
+	return fmt.Sprintf("retry-%dms", delay.Milliseconds())
+}
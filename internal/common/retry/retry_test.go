@@ -0,0 +1,73 @@
+package retry
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	assert := assert.New(t)
+	p := DefaultPolicy()
+	assert.NoError(p.Validate())
+	assert.Equal("retry-10s", p.QueueName(1))
+	assert.Equal("retry-30s", p.QueueName(2))
+	assert.Equal("", p.QueueName(3), "Should return empty string once attempts are exhausted")
+	assert.True(p.Exhausted(3))
+	assert.Equal([]Queue{
+		{Name: "retry-10s", Delay: 10 * time.Second},
+		{Name: "retry-30s", Delay: 30 * time.Second},
+	}, p.Queues())
+	p.MaxAttempts = 4
+	assert.Equal("retry-60s", p.QueueName(3), "One more attempt should use the 60s step")
+	assert.Equal("", p.Expiration(1))
+}
+
+func TestExponentialPolicy(t *testing.T) {
+	assert := assert.New(t)
+	p := Policy{MaxAttempts: 6, InitialDelay: 500 * time.Millisecond, Multiplier: 3, MaxDelay: 10 * time.Second}
+	assert.NoError(p.Validate())
+	assert.Equal(500*time.Millisecond, p.Delay(1))
+	assert.Equal(1500*time.Millisecond, p.Delay(2))
+	assert.Equal(4500*time.Millisecond, p.Delay(3))
+	assert.Equal(10*time.Second, p.Delay(4))
+	assert.Equal(10*time.Second, p.Delay(5))
+	assert.Equal("retry-500ms", p.QueueName(1))
+	assert.Len(p.Queues(), 4, "Capped steps should share one queue")
+}
+
+func TestExpirationJitter(t *testing.T) {
+	p := Policy{MaxAttempts: 2, Delays: []time.Duration{10 * time.Second}, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		ms, err := strconv.Atoi(p.Expiration(1))
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, ms, 8000)
+		assert.LessOrEqual(t, ms, 10000)
+	}
+}
+
+func TestQueueFor(t *testing.T) {
+	assert := assert.New(t)
+	p := DefaultPolicy()
+	q, ok := p.QueueFor(0)
+	assert.True(ok)
+	assert.Equal("retry-10s", q.Name)
+	q, _ = p.QueueFor(25 * time.Second)
+	assert.Equal("retry-30s", q.Name)
+	q, _ = p.QueueFor(time.Hour)
+	assert.Equal("retry-30s", q.Name, "Should fall back to the longest queue")
+	_, ok = Policy{MaxAttempts: 1}.QueueFor(time.Second)
+	assert.False(ok)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	assert.Error(Policy{}.Validate())
+	assert.Error(Policy{MaxAttempts: 3}.Validate())
+	assert.Error(Policy{MaxAttempts: 3, InitialDelay: time.Second, Multiplier: 0.5}.Validate())
+	assert.Error(Policy{MaxAttempts: 3, InitialDelay: time.Minute, Multiplier: 2, MaxDelay: time.Second}.Validate())
+	assert.Error(Policy{MaxAttempts: 3, Delays: []time.Duration{time.Second}, Jitter: 1}.Validate())
+	assert.NoError(Policy{MaxAttempts: 1}.Validate())
+}
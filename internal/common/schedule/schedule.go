@@ -0,0 +1,120 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrNotFound = errors.New("scheduled notification not found")
+
+// Notification is a notification held back until SendAt. Headers are the
+// AMQP headers it is published with, and Owner is the ID of the API key that
+// sent it.
+type Notification struct {
+	MessageId string     `json:"message_id"`
+	Body      []byte     `json:"-"`
+	Headers   amqp.Table `json:"-"`
+	Owner     string     `json:"-"`
+	SendAt    time.Time  `json:"send_at"`
+}
+
+type Store interface {
+	Add(ctx context.Context, n Notification) error
+	Cancel(ctx context.Context, messageId string, owner string) error
+	ReleaseDue(ctx context.Context, now time.Time, limit int, publish func(Notification) error) (int, error)
+}
+
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type PgStore struct {
+	DB DB
+}
+
+func NewPgStore(db DB) *PgStore {
+	return &PgStore{DB: db}
+}
+
+func (p *PgStore) Add(ctx context.Context, n Notification) error {
+	headers, err := json.Marshal(n.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %w", err)
+	}
+	_, err = p.DB.Exec(ctx,
+		`INSERT INTO scheduled_notifications (message_id, owner, headers, body, send_at) VALUES ($1, $2, $3, $4, $5)`,
+		n.MessageId, n.Owner, headers, n.Body, n.SendAt,
+	)
+	return err
+}
+
+// Cancel blocks on rows locked by an in-flight ReleaseDue, so a notification
+// is either cancelled or published, never both. Notifications sent by another
+// owner are reported as not found.
+func (p *PgStore) Cancel(ctx context.Context, messageId string, owner string) error {
+	tag, err := p.DB.Exec(ctx, `DELETE FROM scheduled_notifications WHERE message_id = $1 AND owner = $2`, messageId, owner)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) ReleaseDue(ctx context.Context, now time.Time, limit int, publish func(Notification) error) (int, error) {
+	tx, err := p.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	rows, err := tx.Query(ctx,
+		`SELECT message_id, owner, headers, body, send_at FROM scheduled_notifications
+		WHERE send_at <= $1 ORDER BY send_at LIMIT $2 FOR UPDATE SKIP LOCKED`,
+		now, limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var due []Notification
+	for rows.Next() {
+		var n Notification
+		var headers []byte
+		if err := rows.Scan(&n.MessageId, &n.Owner, &headers, &n.Body, &n.SendAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal(headers, &n.Headers); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to unmarshal headers of %s: %w", n.MessageId, err)
+		}
+		due = append(due, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	released := 0
+	var publishErr error
+	for _, n := range due {
+		if publishErr = publish(n); publishErr != nil {
+			break
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM scheduled_notifications WHERE message_id = $1`, n.MessageId); err != nil {
+			return 0, err
+		}
+		released++
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return released, publishErr
+}
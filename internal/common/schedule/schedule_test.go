@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgStore_Add(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	sendAt := time.Date(2025, 6, 10, 9, 0, 0, 0, time.UTC)
+	mockDB.ExpectExec(`INSERT INTO scheduled_notifications`).
+		WithArgs("msg-1", "key-1", []byte(`{"x-sender":"billing@example.com"}`), []byte(`{"email":"a@b.com"}`), sendAt).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	err = NewPgStore(mockDB).Add(context.Background(), Notification{
+		MessageId: "msg-1",
+		Body:      []byte(`{"email":"a@b.com"}`),
+		Headers:   amqp.Table{"x-sender": "billing@example.com"},
+		Owner:     "key-1",
+		SendAt:    sendAt,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_Cancel(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectExec(`DELETE FROM scheduled_notifications WHERE message_id = \$1 AND owner = \$2`).
+		WithArgs("msg-1", "key-1").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockDB.ExpectExec(`DELETE FROM scheduled_notifications WHERE message_id = \$1 AND owner = \$2`).
+		WithArgs("fired", "key-1").
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+	store := NewPgStore(mockDB)
+	assert.NoError(t, store.Cancel(context.Background(), "msg-1", "key-1"))
+	assert.ErrorIs(t, store.Cancel(context.Background(), "fired", "key-1"), ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_ReleaseDue(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	now := time.Date(2025, 6, 10, 9, 0, 0, 0, time.UTC)
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT message_id, owner, headers, body, send_at FROM scheduled_notifications`).
+		WithArgs(now, 10).
+		WillReturnRows(pgxmock.NewRows([]string{"message_id", "owner", "headers", "body", "send_at"}).
+			AddRow("msg-1", "key-1", []byte(`{"x-sender":"billing@example.com"}`), []byte(`{}`), now.Add(-time.Minute)).
+			AddRow("msg-2", "key-1", []byte(`{}`), []byte(`{}`), now))
+	mockDB.ExpectExec(`DELETE FROM scheduled_notifications WHERE message_id = \$1`).
+		WithArgs("msg-1").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockDB.ExpectCommit()
+
+	var published []string
+	released, err := NewPgStore(mockDB).ReleaseDue(context.Background(), now, 10, func(n Notification) error {
+		if n.MessageId == "msg-2" {
+			return errors.New("channel closed")
+		}
+		assert.Equal(t, amqp.Table{"x-sender": "billing@example.com"}, n.Headers)
+		published = append(published, n.MessageId)
+		return nil
+	})
+
+	assert.EqualError(t, err, "channel closed")
+	assert.Equal(t, 1, released)
+	assert.Equal(t, []string{"msg-1"}, published)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
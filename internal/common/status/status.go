@@ -0,0 +1,94 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	Scheduled    = "scheduled"
+	Cancelled    = "cancelled"
+	Queued       = "queued"
+	Sending      = "sending"
+	Delivered    = "delivered"
+	DeadLettered = "dead-lettered"
+)
+
+var ErrNotFound = errors.New("notification status not found")
+
+func Retrying(attempt int) string {
+	return fmt.Sprintf("retrying(%d)", attempt)
+}
+
+type Status struct {
+	MessageId string    `json:"message_id"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Owner     string    `json:"-"`
+}
+
+// Store tracks notification statuses. Track records a notification with the
+// ID of the API key that sent it. Set changes the status and keeps the owner;
+// for a notification that was never tracked it creates an entry with the
+// empty owner.
+type Store interface {
+	Track(ctx context.Context, messageId string, owner string, status string) error
+	Set(ctx context.Context, messageId string, status string) error
+	Get(ctx context.Context, messageId string) (Status, error)
+	Delete(ctx context.Context, messageId string) error
+}
+
+type DB interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type PgStore struct {
+	DB DB
+}
+
+func NewPgStore(db DB) *PgStore {
+	return &PgStore{DB: db}
+}
+
+func (p *PgStore) Track(ctx context.Context, messageId string, owner string, status string) error {
+	_, err := p.DB.Exec(ctx,
+		`INSERT INTO notification_status (message_id, owner, status, updated_at) VALUES ($1, $2, $3, now())
+		ON CONFLICT (message_id) DO UPDATE SET owner = EXCLUDED.owner, status = EXCLUDED.status, updated_at = EXCLUDED.updated_at`,
+		messageId, owner, status,
+	)
+	return err
+}
+
+func (p *PgStore) Set(ctx context.Context, messageId string, status string) error {
+	_, err := p.DB.Exec(ctx,
+		`INSERT INTO notification_status (message_id, status, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (message_id) DO UPDATE SET status = EXCLUDED.status, updated_at = EXCLUDED.updated_at`,
+		messageId, status,
+	)
+	return err
+}
+
+func (p *PgStore) Get(ctx context.Context, messageId string) (Status, error) {
+	s := Status{MessageId: messageId}
+	err := p.DB.QueryRow(ctx,
+		`SELECT status, updated_at, owner FROM notification_status WHERE message_id = $1`, messageId,
+	).Scan(&s.Status, &s.UpdatedAt, &s.Owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Status{}, ErrNotFound
+	}
+	if err != nil {
+		return Status{}, err
+	}
+	return s, nil
+}
+
+func (p *PgStore) Delete(ctx context.Context, messageId string) error {
+	_, err := p.DB.Exec(ctx, `DELETE FROM notification_status WHERE message_id = $1`, messageId)
+	return err
+}
@@ -0,0 +1,62 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgStore_Set(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectExec(`INSERT INTO notification_status`).
+		WithArgs("msg-1", "retrying(2)").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	err = NewPgStore(mockDB).Set(context.Background(), "msg-1", Retrying(2))
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_Track(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectExec(`INSERT INTO notification_status \(message_id, owner, status, updated_at\)`).
+		WithArgs("msg-1", "key-1", Queued).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	err = NewPgStore(mockDB).Track(context.Background(), "msg-1", "key-1", Queued)
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_Get(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	updatedAt := time.Now().UTC()
+	mockDB.ExpectQuery(`SELECT status, updated_at, owner FROM notification_status WHERE message_id = \$1`).
+		WithArgs("msg-1").
+		WillReturnRows(pgxmock.NewRows([]string{"status", "updated_at", "owner"}).AddRow(Delivered, updatedAt, "key-1"))
+	mockDB.ExpectQuery(`SELECT status, updated_at, owner FROM notification_status WHERE message_id = \$1`).
+		WithArgs("missing").
+		WillReturnError(pgx.ErrNoRows)
+
+	store := NewPgStore(mockDB)
+	st, err := store.Get(context.Background(), "msg-1")
+	assert.NoError(t, err)
+	assert.Equal(t, Status{MessageId: "msg-1", Status: Delivered, UpdatedAt: updatedAt, Owner: "key-1"}, st)
+
+	_, err = store.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,50 @@
+// Package statustest provides an in-memory status.Store for tests.
+package statustest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+)
+
+type MemoryStore struct {
+	mu       sync.RWMutex
+	statuses map[string]status.Status
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{statuses: make(map[string]status.Status)}
+}
+
+func (m *MemoryStore) Track(_ context.Context, messageId string, owner string, state string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses[messageId] = status.Status{MessageId: messageId, Status: state, UpdatedAt: time.Now().UTC(), Owner: owner}
+	return nil
+}
+
+func (m *MemoryStore) Set(_ context.Context, messageId string, state string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses[messageId] = status.Status{MessageId: messageId, Status: state, UpdatedAt: time.Now().UTC(), Owner: m.statuses[messageId].Owner}
+	return nil
+}
+
+func (m *MemoryStore) Get(_ context.Context, messageId string) (status.Status, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.statuses[messageId]
+	if !ok {
+		return status.Status{}, status.ErrNotFound
+	}
+	return s, nil
+}
+
+func (m *MemoryStore) Delete(_ context.Context, messageId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.statuses, messageId)
+	return nil
+}
@@ -0,0 +1,21 @@
+package statustest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore_SetKeepsOwner(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	assert.NoError(t, store.Track(ctx, "msg-1", "key-1", status.Queued))
+	assert.NoError(t, store.Set(ctx, "msg-1", status.Delivered))
+
+	st, err := store.Get(ctx, "msg-1")
+	assert.NoError(t, err)
+	assert.Equal(t, status.Delivered, st.Status)
+	assert.Equal(t, "key-1", st.Owner)
+}
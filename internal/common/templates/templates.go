@@ -0,0 +1,252 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"regexp"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	FormatText = "text"
+	FormatHTML = "html"
+)
+
+var (
+	ErrNotFound = errors.New("template not found")
+	ErrExists   = errors.New("template already exists")
+	ErrConflict = errors.New("template is being updated concurrently")
+	idPattern   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,127}$`)
+)
+
+type Template struct {
+	ID        string    `json:"id"`
+	Version   int       `json:"version"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Rendered struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	Format  string `json:"format"`
+}
+
+type RenderError struct {
+	TemplateId string
+	Err        error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("template %s: %s", e.TemplateId, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+func (t Template) Validate() error {
+	if !idPattern.MatchString(t.ID) {
+		return errors.New("id must be 1-128 characters of letters, digits, '.', '_' or '-'")
+	}
+	if t.Body == "" {
+		return errors.New("body is required")
+	}
+	if t.Format != FormatText && t.Format != FormatHTML {
+		return fmt.Errorf("format must be %q or %q", FormatText, FormatHTML)
+	}
+	if _, err := texttemplate.New("subject").Parse(t.Subject); err != nil {
+		return fmt.Errorf("subject: %w", err)
+	}
+	if err := t.parseBody(); err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	return nil
+}
+
+func (t Template) parseBody() error {
+	if t.Format == FormatHTML {
+		_, err := htmltemplate.New("body").Parse(t.Body)
+		return err
+	}
+	_, err := texttemplate.New("body").Parse(t.Body)
+	return err
+}
+
+func (t Template) Render(data map[string]any) (Rendered, error) {
+	subject, err := renderText(t.Subject, data)
+	if err != nil {
+		return Rendered{}, &RenderError{TemplateId: t.ID, Err: fmt.Errorf("subject: %w", err)}
+	}
+	var body string
+	if t.Format == FormatHTML {
+		body, err = renderHTML(t.Body, data)
+	} else {
+		body, err = renderText(t.Body, data)
+	}
+	if err != nil {
+		return Rendered{}, &RenderError{TemplateId: t.ID, Err: fmt.Errorf("body: %w", err)}
+	}
+	return Rendered{Subject: subject, Body: body, Format: t.Format}, nil
+}
+
+func renderText(src string, data map[string]any) (string, error) {
+	tmpl, err := texttemplate.New("").Option("missingkey=error").Parse(src)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func renderHTML(src string, data map[string]any) (string, error) {
+	tmpl, err := htmltemplate.New("").Option("missingkey=error").Parse(src)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type Store interface {
+	Create(ctx context.Context, t Template) (Template, error)
+	Update(ctx context.Context, t Template) (Template, error)
+	Get(ctx context.Context, id string, version int) (Template, error)
+	List(ctx context.Context) ([]Template, error)
+	Versions(ctx context.Context, id string) ([]Template, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type DB interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type PgStore struct {
+	DB DB
+}
+
+func NewPgStore(db DB) *PgStore {
+	return &PgStore{DB: db}
+}
+
+const templateColumns = `id, version, subject, body, format, created_at`
+
+func (p *PgStore) Create(ctx context.Context, t Template) (Template, error) {
+	err := p.DB.QueryRow(ctx,
+		`INSERT INTO templates (id, version, subject, body, format) VALUES ($1, 1, $2, $3, $4)
+		ON CONFLICT (id, version) DO NOTHING
+		RETURNING `+templateColumns,
+		t.ID, t.Subject, t.Body, t.Format,
+	).Scan(&t.ID, &t.Version, &t.Subject, &t.Body, &t.Format, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Template{}, ErrExists
+	}
+	return t, err
+}
+
+// updateAttempts bounds how often Update retries after losing a race for the
+// next version number.
+const updateAttempts = 3
+
+// Update stores t as the next version of an existing template. Two updates
+// racing for the same version collide on the primary key; the loser retries
+// with the version after, and gives up with ErrConflict.
+func (p *PgStore) Update(ctx context.Context, t Template) (Template, error) {
+	for attempt := 1; ; attempt++ {
+		updated, err := p.insertVersion(ctx, t)
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+			return updated, err
+		}
+		if attempt == updateAttempts {
+			return Template{}, ErrConflict
+		}
+	}
+}
+
+const uniqueViolation = "23505"
+
+func (p *PgStore) insertVersion(ctx context.Context, t Template) (Template, error) {
+	err := p.DB.QueryRow(ctx,
+		`INSERT INTO templates (id, version, subject, body, format)
+		SELECT $1, max(version) + 1, $2, $3, $4 FROM templates WHERE id = $1 HAVING count(*) > 0
+		RETURNING `+templateColumns,
+		t.ID, t.Subject, t.Body, t.Format,
+	).Scan(&t.ID, &t.Version, &t.Subject, &t.Body, &t.Format, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Template{}, ErrNotFound
+	}
+	return t, err
+}
+
+func (p *PgStore) Get(ctx context.Context, id string, version int) (Template, error) {
+	var t Template
+	var row pgx.Row
+	if version > 0 {
+		row = p.DB.QueryRow(ctx, `SELECT `+templateColumns+` FROM templates WHERE id = $1 AND version = $2`, id, version)
+	} else {
+		row = p.DB.QueryRow(ctx, `SELECT `+templateColumns+` FROM templates WHERE id = $1 ORDER BY version DESC LIMIT 1`, id)
+	}
+	err := row.Scan(&t.ID, &t.Version, &t.Subject, &t.Body, &t.Format, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Template{}, ErrNotFound
+	}
+	return t, err
+}
+
+func (p *PgStore) List(ctx context.Context) ([]Template, error) {
+	return p.query(ctx, `SELECT DISTINCT ON (id) `+templateColumns+` FROM templates ORDER BY id, version DESC`)
+}
+
+func (p *PgStore) Versions(ctx context.Context, id string) ([]Template, error) {
+	versions, err := p.query(ctx, `SELECT `+templateColumns+` FROM templates WHERE id = $1 ORDER BY version DESC`, id)
+	if err == nil && len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, err
+}
+
+func (p *PgStore) Delete(ctx context.Context, id string) error {
+	tag, err := p.DB.Exec(ctx, `DELETE FROM templates WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) query(ctx context.Context, sql string, args ...any) ([]Template, error) {
+	rows, err := p.DB.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	templates := []Template{}
+	for rows.Next() {
+		var t Template
+		if err := rows.Scan(&t.ID, &t.Version, &t.Subject, &t.Body, &t.Format, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
@@ -0,0 +1,129 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender_Text(t *testing.T) {
+	tmpl := Template{ID: "welcome", Subject: "Hi {{.name}}", Body: "Welcome <b>{{.name}}</b>", Format: FormatText}
+	require.NoError(t, tmpl.Validate())
+
+	rendered, err := tmpl.Render(map[string]any{"name": "Ada"})
+
+	require.NoError(t, err)
+	assert.Equal(t, Rendered{Subject: "Hi Ada", Body: "Welcome <b>Ada</b>", Format: FormatText}, rendered)
+}
+
+func TestRender_HTMLEscapesData(t *testing.T) {
+	tmpl := Template{ID: "receipt", Subject: "Receipt for {{.name}}", Body: "<p>Hello {{.name}}</p>", Format: FormatHTML}
+
+	rendered, err := tmpl.Render(map[string]any{"name": "<script>"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Receipt for <script>", rendered.Subject)
+	assert.Equal(t, "<p>Hello &lt;script&gt;</p>", rendered.Body)
+}
+
+func TestRender_MissingKey(t *testing.T) {
+	tmpl := Template{ID: "welcome", Subject: "Hi", Body: "Hello {{.name}}", Format: FormatText}
+
+	_, err := tmpl.Render(map[string]any{})
+
+	var renderErr *RenderError
+	assert.ErrorAs(t, err, &renderErr)
+	assert.Equal(t, "welcome", renderErr.TemplateId)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	assert.Error(Template{ID: "", Body: "x", Format: FormatText}.Validate())
+	assert.Error(Template{ID: "bad id", Body: "x", Format: FormatText}.Validate())
+	assert.Error(Template{ID: "ok", Body: "", Format: FormatText}.Validate())
+	assert.Error(Template{ID: "ok", Body: "x", Format: "markdown"}.Validate())
+	assert.Error(Template{ID: "ok", Body: "{{.name", Format: FormatText}.Validate())
+	assert.Error(Template{ID: "ok", Subject: "{{end}}", Body: "x", Format: FormatHTML}.Validate())
+	assert.NoError(Template{ID: "order.shipped-v2", Body: "x", Format: FormatHTML}.Validate())
+}
+
+func TestPgStore_CreateExisting(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectQuery(`INSERT INTO templates`).
+		WithArgs("welcome", "Hi", "Hello", FormatText).
+		WillReturnError(pgx.ErrNoRows)
+
+	_, err = NewPgStore(mockDB).Create(context.Background(), Template{ID: "welcome", Subject: "Hi", Body: "Hello", Format: FormatText})
+	assert.True(t, errors.Is(err, ErrExists))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_UpdateCreatesNewVersion(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	createdAt := time.Now()
+	mockDB.ExpectQuery(`INSERT INTO templates \(id, version, subject, body, format\)\s+SELECT \$1, max\(version\) \+ 1`).
+		WithArgs("welcome", "Hi", "Hello again", FormatText).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "version", "subject", "body", "format", "created_at"}).
+			AddRow("welcome", 3, "Hi", "Hello again", FormatText, createdAt))
+
+	updated, err := NewPgStore(mockDB).Update(context.Background(), Template{ID: "welcome", Subject: "Hi", Body: "Hello again", Format: FormatText})
+	require.NoError(t, err)
+	assert.Equal(t, 3, updated.Version)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_UpdateRetriesVersionConflict(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	conflict := &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint \"templates_pkey\""}
+	mockDB.ExpectQuery(`INSERT INTO templates`).
+		WithArgs("welcome", "Hi", "Hello again", FormatText).
+		WillReturnError(conflict)
+	mockDB.ExpectQuery(`INSERT INTO templates`).
+		WithArgs("welcome", "Hi", "Hello again", FormatText).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "version", "subject", "body", "format", "created_at"}).
+			AddRow("welcome", 4, "Hi", "Hello again", FormatText, time.Now()))
+	for i := 0; i < updateAttempts; i++ {
+		mockDB.ExpectQuery(`INSERT INTO templates`).
+			WithArgs("busy", "Hi", "Hello again", FormatText).
+			WillReturnError(conflict)
+	}
+
+	store := NewPgStore(mockDB)
+	updated, err := store.Update(context.Background(), Template{ID: "welcome", Subject: "Hi", Body: "Hello again", Format: FormatText})
+	require.NoError(t, err)
+	assert.Equal(t, 4, updated.Version)
+
+	_, err = store.Update(context.Background(), Template{ID: "busy", Subject: "Hi", Body: "Hello again", Format: FormatText})
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPgStore_GetLatest(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectQuery(`FROM templates WHERE id = \$1 ORDER BY version DESC LIMIT 1`).
+		WithArgs("missing").
+		WillReturnError(pgx.ErrNoRows)
+
+	_, err = NewPgStore(mockDB).Get(context.Background(), "missing", 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
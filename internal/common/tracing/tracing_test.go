@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "test", ExporterNone, "")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	shutdown, err = Setup(context.Background(), "test", ExporterStdout, "")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), "test", "jaeger", "")
+	assert.Error(t, err)
+}
+
+func TestInjectExtract_SurvivesDLQRoundTrip(t *testing.T) {
+	_, err := Setup(context.Background(), "test", ExporterNone, "")
+	require.NoError(t, err)
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "ingest")
+	defer span.End()
+
+	headers := Inject(ctx, amqp.Table{"x-retry-count": int32(2)})
+	assert.Contains(t, headers, "traceparent")
+	assert.Equal(t, int32(2), headers["x-retry-count"])
+
+	// The DLQ worker stores headers as JSONB and the inspector reads them back.
+	stored, err := json.Marshal(headers)
+	require.NoError(t, err)
+	var loaded amqp.Table
+	require.NoError(t, json.Unmarshal(stored, &loaded))
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), loaded))
+	assert.True(t, extracted.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+}
+
+func TestExtract_WithoutHeaders(t *testing.T) {
+	ctx := Extract(context.Background(), nil)
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
@@ -1,7 +1,234 @@
 package common
 
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelSMS     = "sms"
+)
+
+const (
+	InvalidRecipientsReject    = "reject"
+	InvalidRecipientsSendValid = "send_valid"
+)
+
+const MaxAttachmentBytes = 10 << 20
+
+var phonePattern = regexp.MustCompile(`^\+?[1-9][0-9]{6,14}$`)
+
 type RequestBody struct {
-	Email   string `json:"email"`
+	Channel string   `json:"channel,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	To      []string `json:"to,omitempty"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	ReplyTo string   `json:"reply_to,omitempty"`
+	From    string   `json:"from,omitempty"`
+
+	OnInvalidRecipients string `json:"on_invalid_recipients,omitempty"`
+
+	Phone   string `json:"phone,omitempty"`
+	URL     string `json:"url,omitempty"`
 	Message string `json:"message"`
 	Subject string `json:"subject"`
+
+	HTML        string       `json:"html,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	TemplateId      string         `json:"template_id,omitempty"`
+	TemplateVersion int            `json:"template_version,omitempty"`
+	Data            map[string]any `json:"data,omitempty"`
+
+	SendAt *time.Time `json:"send_at,omitempty"`
+	Delay  string     `json:"delay,omitempty"`
+}
+
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Content     []byte `json:"content"`
+}
+
+func (r RequestBody) Recipients() []string {
+	if r.Email == "" {
+		return r.To
+	}
+	return append([]string{r.Email}, r.To...)
+}
+
+func (r RequestBody) ChannelName() string {
+	if r.Channel == "" {
+		return ChannelEmail
+	}
+	return r.Channel
+}
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors reports every invalid field of a request at once.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks r before it is queued. allowedFrom lists the addresses r
+// may be sent from; any other from is rejected so a client cannot send as
+// someone else.
+func (r RequestBody) Validate(allowedFrom []string) error {
+	switch r.ChannelName() {
+	case ChannelEmail:
+		if err := r.validateEmailAddresses(); err != nil {
+			return err
+		}
+	case ChannelWebhook:
+		if r.URL == "" {
+			return &ValidationError{Field: "url", Message: "is required"}
+		}
+		if !validHTTPURL(r.URL) {
+			return &ValidationError{Field: "url", Message: "is not a valid http(s) URL"}
+		}
+	case ChannelSlack:
+		if r.URL != "" && !validHTTPURL(r.URL) {
+			return &ValidationError{Field: "url", Message: "is not a valid http(s) URL"}
+		}
+	case ChannelSMS:
+		if !phonePattern.MatchString(r.Phone) {
+			return &ValidationError{Field: "phone", Message: "is not a valid phone number"}
+		}
+	default:
+		return &ValidationError{Field: "channel", Message: fmt.Sprintf("%q is not supported", r.Channel)}
+	}
+	if r.From != "" && !senderAllowed(r.From, allowedFrom) {
+		return &ValidationError{Field: "from", Message: "is not an allowed sender"}
+	}
+	if r.TemplateId == "" && r.Message == "" && r.HTML == "" {
+		return &ValidationError{Field: "message", Message: "is required"}
+	}
+	if r.TemplateId != "" && (r.Message != "" || r.HTML != "") {
+		return &ValidationError{Field: "template_id", Message: "cannot be combined with message or html"}
+	}
+	if len(r.Attachments) > 0 && r.ChannelName() != ChannelEmail {
+		return &ValidationError{Field: "attachments", Message: "are only supported for email"}
+	}
+	total := 0
+	for i, a := range r.Attachments {
+		if a.Filename == "" {
+			return &ValidationError{Field: fmt.Sprintf("attachments[%d].filename", i), Message: "is required"}
+		}
+		total += len(a.Content)
+	}
+	if total > MaxAttachmentBytes {
+		return &ValidationError{Field: "attachments", Message: fmt.Sprintf("must not exceed %d bytes in total", MaxAttachmentBytes)}
+	}
+	return nil
+}
+
+func (r RequestBody) ScheduledAt(now time.Time) (time.Time, bool, error) {
+	if r.SendAt != nil && r.Delay != "" {
+		return time.Time{}, false, &ValidationError{Field: "send_at", Message: "cannot be combined with delay"}
+	}
+	if r.Delay != "" {
+		d, err := time.ParseDuration(r.Delay)
+		if err != nil || d < 0 {
+			return time.Time{}, false, &ValidationError{Field: "delay", Message: "is not a valid duration"}
+		}
+		return now.Add(d), d > 0, nil
+	}
+	if r.SendAt != nil {
+		return *r.SendAt, r.SendAt.After(now), nil
+	}
+	return now, false, nil
+}
+
+func validHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (r RequestBody) validateEmailAddresses() error {
+	if r.OnInvalidRecipients != "" && r.OnInvalidRecipients != InvalidRecipientsReject && r.OnInvalidRecipients != InvalidRecipientsSendValid {
+		return &ValidationError{Field: "on_invalid_recipients", Message: fmt.Sprintf("must be %q or %q", InvalidRecipientsReject, InvalidRecipientsSendValid)}
+	}
+	if len(r.Recipients()) == 0 {
+		return &ValidationError{Field: "email", Message: "or to is required"}
+	}
+	fields := []struct {
+		name      string
+		addresses []string
+	}{
+		{"email", nonEmpty(r.Email)},
+		{"to", r.To},
+		{"cc", r.Cc},
+		{"bcc", r.Bcc},
+		{"reply_to", nonEmpty(r.ReplyTo)},
+		{"from", nonEmpty(r.From)},
+	}
+	validRecipients := 0
+	// reply_to and from are not recipients, so send_valid cannot drop them.
+	mustReject := false
+	var invalid ValidationErrors
+	for _, f := range fields {
+		for i, address := range f.addresses {
+			if _, err := mail.ParseAddress(address); err != nil {
+				field := f.name
+				if f.name == "to" || f.name == "cc" || f.name == "bcc" {
+					field = fmt.Sprintf("%s[%d]", f.name, i)
+				}
+				invalid = append(invalid, &ValidationError{Field: field, Message: "is not a valid address"})
+				mustReject = mustReject || f.name == "reply_to" || f.name == "from"
+				continue
+			}
+			if f.name == "email" || f.name == "to" {
+				validRecipients++
+			}
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	if r.OnInvalidRecipients == InvalidRecipientsSendValid && validRecipients > 0 && !mustReject {
+		return nil
+	}
+	return invalid
+}
+
+func senderAllowed(from string, allowed []string) bool {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if parsed, err := mail.ParseAddress(a); err == nil && strings.EqualFold(parsed.Address, addr.Address) {
+			return true
+		}
+	}
+	return false
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
 }
@@ -2,11 +2,16 @@ package util
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
 	constants "github.com/jayanth-parthsarathy/notify/internal/common/constants"
 	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/retry"
 	"github.com/joho/godotenv"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -16,17 +21,33 @@ func LoadEnv() {
 	logs.LogError(err, "Failed to load .env")
 }
 
-func ConnectToRabbitMQ() *amqp.Connection {
-	rabbitmqUrl := os.Getenv("RABBIT_MQ_URL")
-	conn, err := amqp.Dial(rabbitmqUrl)
-	logs.FailOnError(err, "Failed to connect to RabbitMQ")
-	return conn
+// Serve runs srv until ctx is cancelled, then stops accepting connections and
+// waits up to shutdownTimeout for in-flight requests to finish.
+func Serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
 }
 
-func CreateChannel(conn *amqp.Connection) *amqp.Channel {
-	ch, err := conn.Channel()
-	logs.FailOnError(err, "Failed to create channel")
-	return ch
+func ConnectToRabbitMQ(url string, setup func(*amqp.Connection) error) *connection.Manager {
+	manager := connection.NewManager(url, setup)
+	manager.Start()
+	return manager
+}
+
+func DeclareTopology(policy retry.Policy) func(*amqp.Connection) error {
+	return func(conn *amqp.Connection) error {
+		return DeclareQueue(conn, policy)
+	}
 }
 
 func declareExchange(ch *amqp.Channel) error {
@@ -42,32 +63,20 @@ func declareExchange(ch *amqp.Channel) error {
 	return err
 }
 
-func DeclareQueue(conn *amqp.Connection) {
+func DeclareQueue(conn *amqp.Connection, policy retry.Policy) error {
 	ch, err := conn.Channel()
-	logs.FailOnError(err, "Failed to create channel")
+	if err != nil {
+		return fmt.Errorf("failed to create channel: %w", err)
+	}
 	defer ch.Close()
 	err = declareExchange(ch)
-	logs.FailOnError(err, "Failed to declare exchange")
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange: %w", err)
+	}
 	mainQueueArgs := amqp.Table{
 		"x-dead-letter-exchange":    "",
 		"x-dead-letter-routing-key": constants.DLQName,
 	}
-	retry10sArgs := amqp.Table{
-		"x-dead-letter-exchange":    "",
-		"x-dead-letter-routing-key": constants.MainQueueName,
-		"x-message-ttl":             int32(10000),
-	}
-	retry30sArgs := amqp.Table{
-		"x-dead-letter-exchange":    "",
-		"x-dead-letter-routing-key": constants.MainQueueName,
-		"x-message-ttl":             int32(30000),
-	}
-	retry60sArgs := amqp.Table{
-		"x-dead-letter-exchange":    "",
-		"x-dead-letter-routing-key": constants.MainQueueName,
-		"x-message-ttl":             int32(60000),
-	}
-
 	_, err = ch.QueueDeclare(
 		constants.MainQueueName, // name of the queue
 		true,                    // durable
@@ -76,8 +85,9 @@ func DeclareQueue(conn *amqp.Connection) {
 		false,                   // no-wait
 		mainQueueArgs,           // arguments
 	)
-	logs.FailOnError(err, "Failed to create main_queue")
-	logs.FailOnError(err, "Failed to bind retry_queue with retry_exchange")
+	if err != nil {
+		return fmt.Errorf("failed to create main_queue: %w", err)
+	}
 	_, err = ch.QueueDeclare(
 		constants.DLQName,
 		true,
@@ -86,62 +96,48 @@ func DeclareQueue(conn *amqp.Connection) {
 		false,
 		nil,
 	)
-	logs.FailOnError(err, "Failed to declare dlq")
-	_, err = ch.QueueDeclare(
-		constants.Retry10sQueue,
-		true,
-		false,
-		false,
-		false,
-		retry10sArgs,
-	)
-	logs.FailOnError(err, "Failed to declare retry-10s")
-	_, err = ch.QueueDeclare(
-		constants.Retry30sQueue,
-		true,
-		false,
-		false,
-		false,
-		retry30sArgs,
-	)
-	logs.FailOnError(err, "Failed to declare retry-30s")
-	_, err = ch.QueueDeclare(
-		constants.Retry60sQueue,
-		true,
-		false,
-		false,
-		false,
-		retry60sArgs,
-	)
-	logs.FailOnError(err, "Failed to declare retry-60s")
-	err = ch.QueueBind(
-		constants.Retry10sQueue,
-		constants.Retry10sQueue,
-		constants.RetryExchangeName,
-		false,
-		nil,
-	)
-	logs.FailOnError(err, "Failed to bind retry-10s with retry_exchange")
-	err = ch.QueueBind(
-		constants.Retry30sQueue,
-		constants.Retry30sQueue,
-		constants.RetryExchangeName,
-		false,
-		nil,
-	)
-	logs.FailOnError(err, "Failed to bind retry-30s with retry_exchange")
-	err = ch.QueueBind(
-		constants.Retry60sQueue,
-		constants.Retry60sQueue,
-		constants.RetryExchangeName,
-		false,
-		nil,
-	)
-	logs.FailOnError(err, "Failed to bind retry-60s with retry_exchange")
+	if err != nil {
+		return fmt.Errorf("failed to declare dlq: %w", err)
+	}
+	for _, q := range policy.Queues() {
+		retryArgs := amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": constants.MainQueueName,
+			"x-message-ttl":             int32(q.Delay.Milliseconds()),
+		}
+		_, err = ch.QueueDeclare(
+			q.Name,
+			true,
+			false,
+			false,
+			false,
+			retryArgs,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare %s: %w", q.Name, err)
+		}
+		err = ch.QueueBind(
+			q.Name,
+			q.Name,
+			constants.RetryExchangeName,
+			false,
+			nil,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to bind %s with retry_exchange: %w", q.Name, err)
+		}
+	}
+	return nil
 }
 
-func ConnectToDB() *pgx.Conn {
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+func ConnectToDB(url string) *pgx.Conn {
+	conn, err := pgx.Connect(context.Background(), url)
 	logs.FailOnError(err, "Unable to connect to database")
 	return conn
 }
+
+func ConnectToDBPool(url string) *pgxpool.Pool {
+	pool, err := pgxpool.Connect(context.Background(), url)
+	logs.FailOnError(err, "Unable to connect to database")
+	return pool
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe_DrainsInFlightRequestsOnShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, srv, time.Second)
+	}()
+
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		for {
+			r, err := http.Get("http://" + addr)
+			if err == nil {
+				respCh <- r
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	<-started
+	cancel()
+
+	resp := <-respCh
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "done", string(body))
+	assert.NoError(t, <-served)
+
+	_, err = http.Get("http://" + addr)
+	assert.Error(t, err)
+}
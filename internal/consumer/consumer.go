@@ -3,20 +3,64 @@ package consumer
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/jackc/pgx/v4"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
 	constants "github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	"github.com/jayanth-parthsarathy/notify/internal/common/health"
 	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/metrics"
+	"github.com/jayanth-parthsarathy/notify/internal/common/retry"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/templates"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
 	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
-	"github.com/jayanth-parthsarathy/notify/internal/common/util"
 	consumer_types "github.com/jayanth-parthsarathy/notify/internal/consumer/types"
+	consumer_util "github.com/jayanth-parthsarathy/notify/internal/consumer/util"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const channelRestartDelay = time.Second
+
+type Consumer struct {
+	Providers         *consumer_types.Registry
+	Status            status.Store
+	Templates         templates.Store
+	Policy            retry.Policy
+	Schedule          schedule.Store
+	SchedulerInterval time.Duration
+	ConfirmTimeout    time.Duration
+	ShutdownTimeout   time.Duration
+	Workers           int
+	Prefetch          int
+	Addr              string
+	Health            *health.Checker
+}
+
+func NewConsumer(providers *consumer_types.Registry, statusStore status.Store, templateStore templates.Store, policy retry.Policy, scheduleStore schedule.Store) *Consumer {
+	return &Consumer{Providers: providers, Status: statusStore, Templates: templateStore, Policy: policy, Schedule: scheduleStore, SchedulerInterval: time.Second, ConfirmTimeout: 5 * time.Second, ShutdownTimeout: 25 * time.Second, Workers: 5, Prefetch: 1, Addr: ":8092", Health: health.NewChecker()}
+}
+
+func (c *Consumer) setStatus(d consumer_types.Delivery, state string) {
+	if c.Status == nil || d.MessageId() == "" {
+		return
+	}
+	err := c.Status.Set(context.Background(), d.MessageId(), state)
+	logs.LogError(err, "Failed to set notification status")
+}
+
 func getRetryCount(headers amqp.Table) int {
 	if headers == nil {
 		return 0
@@ -33,21 +77,6 @@ func getRetryCount(headers amqp.Table) int {
 	}
 }
 
-func getRetryQueueName(retryCount int) string {
-	var retryQueueName string
-	switch retryCount {
-	case 1:
-		retryQueueName = constants.Retry10sQueue
-	case 2:
-		retryQueueName = constants.Retry30sQueue
-	case 3:
-		retryQueueName = constants.Retry60sQueue
-	default:
-		retryQueueName = ""
-	}
-	return retryQueueName
-}
-
 func populateHeader(headers amqp.Table, retryCount int) amqp.Table {
 	if headers == nil {
 		headers = amqp.Table{}
@@ -56,101 +85,372 @@ func populateHeader(headers amqp.Table, retryCount int) amqp.Table {
 	return headers
 }
 
-func retry(ch consumer_types.Channel, d consumer_types.Delivery, retryCount int) {
+func countMessage(outcome string, attempt int) {
+	metrics.Messages.WithLabelValues(outcome, strconv.Itoa(attempt)).Inc()
+}
+
+func (c *Consumer) retry(ch consumer_types.Channel, d consumer_types.Delivery, retryCount int) {
+	c.retryWithBody(ch, d, retryCount, d.Body(), nil)
+}
+
+// retryWithBody parks body in the next retry queue, or dead-letters it once
+// the policy is exhausted. cause, if known, is recorded in the headers.
+func (c *Consumer) retryWithBody(ch consumer_types.Channel, d consumer_types.Delivery, retryCount int, body []byte, cause error) {
 	log.Debugf("This is the %d attempt", retryCount)
-	retryQueueName := getRetryQueueName(retryCount)
-	if retryCount >= 3 {
-		log.Warnf("Max retries reached. Sending to DLQ: %s", d.Body())
-		err := d.Nack(false, false)
-		logs.LogError(err, "Was not able to nack in retry")
+	if c.Policy.Exhausted(retryCount) {
+		log.Warnf("Max retries reached. Sending to DLQ: %s", body)
+		c.deadLetter(ch, d, body, cause)
+		countMessage("nacked", retryCount)
+		c.setStatus(d, status.DeadLettered)
 		return
 	}
+	retryQueueName := c.Policy.QueueName(retryCount)
 	log.Debugf("This is the %d attempt going to %s queue", retryCount, retryQueueName)
+	countMessage("retried", retryCount)
+	c.park(ch, d, retryQueueName, c.Policy.Expiration(retryCount), retryCount, body, cause)
+}
+
+// parkRateLimited holds a throttled message for at least the wait the limiter
+// or provider asked for, without spending one of its attempts. The shortest
+// retry queue that is long enough is cut down to the wait with a per-message
+// expiration; a longer wait takes several trips through the longest queue.
+func (c *Consumer) parkRateLimited(ch consumer_types.Channel, d consumer_types.Delivery, retryCount int, body []byte, cause error) {
+	wait := consumer_types.RetryAfter(cause)
+	queue, ok := c.Policy.QueueFor(wait)
+	if !ok {
+		c.retryWithBody(ch, d, retryCount+1, body, cause)
+		return
+	}
+	var expiration string
+	if wait > 0 && wait < queue.Delay {
+		expiration = strconv.FormatInt((wait + time.Millisecond - 1).Milliseconds(), 10)
+	}
+	log.Debugf("Rate limited, parking attempt %d in %s queue for %s", retryCount+1, queue.Name, wait)
+	countMessage("rate_limited", retryCount+1)
+	c.park(ch, d, queue.Name, expiration, retryCount, body, cause)
+}
+
+// park acks d and republishes body to a retry queue with retryCount stamped in
+// its headers.
+func (c *Consumer) park(ch consumer_types.Channel, d consumer_types.Delivery, queue string, expiration string, retryCount int, body []byte, cause error) {
 	headers := populateHeader(d.Headers(), retryCount)
+	if cause != nil {
+		recordFailure(headers, cause, time.Now())
+	}
 	err := d.Ack(false)
 	logs.LogError(err, "Failed to ack")
 	err = ch.Publish(
 		constants.RetryExchangeName,
-		retryQueueName,
+		queue,
 		false,
 		false,
 		amqp.Publishing{
 			ContentType:  d.ContentType(),
-			Body:         d.Body(),
+			Body:         body,
 			Headers:      headers,
 			DeliveryMode: amqp.Persistent,
 			MessageId:    d.MessageId(),
+			Expiration:   expiration,
 		},
 	)
 	logs.LogError(err, "Failed to retry")
+	if err == nil {
+		metrics.Retries.WithLabelValues(queue).Inc()
+		c.setStatus(d, status.Retrying(max(retryCount, 1)))
+	}
+}
+
+func splitAddresses(field string, addresses []string, invalid *[]consumer_util.AddressError) ([]string, []string) {
+	valid, errs := consumer_util.ValidateAddresses(field, addresses)
+	var rejected []string
+	for _, e := range errs {
+		rejected = append(rejected, e.Address)
+	}
+	*invalid = append(*invalid, errs...)
+	return valid, rejected
+}
+
+func splitRecipients(reqBody types.RequestBody) (types.RequestBody, types.RequestBody, []consumer_util.AddressError) {
+	var invalid []consumer_util.AddressError
+	valid, rejected := reqBody, reqBody
+	var email []string
+	if reqBody.Email != "" {
+		email = []string{reqBody.Email}
+	}
+	validEmail, rejectedEmail := splitAddresses("email", email, &invalid)
+	validTo, rejectedTo := splitAddresses("to", reqBody.To, &invalid)
+	valid.Email, rejected.Email = "", ""
+	valid.To, rejected.To = append(validEmail, validTo...), append(rejectedEmail, rejectedTo...)
+	valid.Cc, rejected.Cc = splitAddresses("cc", reqBody.Cc, &invalid)
+	valid.Bcc, rejected.Bcc = splitAddresses("bcc", reqBody.Bcc, &invalid)
+	if reqBody.ReplyTo != "" && !consumer_util.Valid(reqBody.ReplyTo) {
+		invalid = append(invalid, consumer_util.AddressError{Field: "reply_to", Address: reqBody.ReplyTo, Reason: "is not a valid address"})
+		valid.ReplyTo = ""
+	}
+	if reqBody.From != "" && !consumer_util.Valid(reqBody.From) {
+		invalid = append(invalid, consumer_util.AddressError{Field: "from", Address: reqBody.From, Reason: "is not a valid address"})
+		valid.From = ""
+	}
+	return valid, rejected, invalid
+}
+
+func copyHeaders(d consumer_types.Delivery) amqp.Table {
+	headers := amqp.Table{}
+	for k, v := range d.Headers() {
+		headers[k] = v
+	}
+	return headers
+}
+
+func publishDeadLetter(ch consumer_types.Channel, d consumer_types.Delivery, body []byte, headers amqp.Table) error {
+	return ch.Publish("", constants.DLQName, false, false, amqp.Publishing{
+		ContentType:  d.ContentType(),
+		Body:         body,
+		Headers:      headers,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    d.MessageId(),
+	})
+}
+
+// deadLetter moves d to the DLQ. A nack cannot change the headers, so when
+// the cause is known the message is republished to the DLQ with the failure
+// recorded instead, falling back to a nack if that fails.
+func (c *Consumer) deadLetter(ch consumer_types.Channel, d consumer_types.Delivery, body []byte, cause error) {
+	if cause != nil {
+		headers := copyHeaders(d)
+		recordFailure(headers, cause, time.Now())
+		headers[constants.OriginalQueueHeader] = constants.MainQueueName
+		err := publishDeadLetter(ch, d, body, headers)
+		logs.LogError(err, "Failed to publish to DLQ, nacking instead")
+		if err == nil {
+			err = d.Ack(false)
+			logs.LogError(err, "Failed to ack dead-lettered message")
+			return
+		}
+	}
+	err := d.Nack(false, false)
+	logs.LogError(err, "Failed to nack to DLQ")
+}
+
+func (c *Consumer) deadLetterRecipients(ch consumer_types.Channel, d consumer_types.Delivery, rejected types.RequestBody, invalid []consumer_util.AddressError) error {
+	body, err := json.Marshal(rejected)
+	if err != nil {
+		return err
+	}
+	headers := copyHeaders(d)
+	reasons := make([]interface{}, len(invalid))
+	for i, e := range invalid {
+		reasons[i] = e.String()
+	}
+	headers["x-invalid-recipients"] = reasons
+	headers[constants.ErrorClassHeader] = consumer_types.ErrorClassPermanent
+	headers[constants.OriginalQueueHeader] = constants.MainQueueName
+	return publishDeadLetter(ch, d, body, headers)
+}
+
+func (c *Consumer) handleInvalidRecipients(ch consumer_types.Channel, d consumer_types.Delivery, reqBody types.RequestBody, retryCount int) (types.RequestBody, []byte, bool) {
+	valid, rejected, invalid := splitRecipients(reqBody)
+	if len(invalid) == 0 {
+		return reqBody, d.Body(), true
+	}
+	for _, e := range invalid {
+		log.Warnf("Invalid recipient %s", e)
+	}
+	if reqBody.OnInvalidRecipients != types.InvalidRecipientsSendValid || len(valid.Recipients()) == 0 {
+		reasons := make([]string, len(invalid))
+		for i, e := range invalid {
+			reasons[i] = e.String()
+		}
+		c.deadLetter(ch, d, d.Body(), &consumer_types.InvalidEmailError{Email: strings.Join(reasons, "; "), Message: "Invalid recipients sending it to DLQ"})
+		countMessage("nacked", retryCount+1)
+		c.setStatus(d, status.DeadLettered)
+		return reqBody, nil, false
+	}
+	body, err := json.Marshal(valid)
+	if err == nil {
+		err = c.deadLetterRecipients(ch, d, rejected, invalid)
+	}
+	if err != nil {
+		logs.LogError(err, "Failed to dead-letter invalid recipients")
+		c.retry(ch, d, retryCount+1)
+		return reqBody, nil, false
+	}
+	return valid, body, true
+}
+
+func (c *Consumer) render(ctx context.Context, reqBody *types.RequestBody) error {
+	if reqBody.TemplateId == "" {
+		return nil
+	}
+	if c.Templates == nil {
+		return &consumer_types.TemplateError{TemplateId: reqBody.TemplateId, Err: templates.ErrNotFound}
+	}
+	t, err := c.Templates.Get(ctx, reqBody.TemplateId, reqBody.TemplateVersion)
+	if errors.Is(err, templates.ErrNotFound) {
+		return &consumer_types.TemplateError{TemplateId: reqBody.TemplateId, Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	rendered, err := t.Render(reqBody.Data)
+	if err != nil {
+		return &consumer_types.TemplateError{TemplateId: reqBody.TemplateId, Err: err}
+	}
+	reqBody.Subject = rendered.Subject
+	if rendered.Format == templates.FormatHTML && reqBody.ChannelName() == types.ChannelEmail {
+		reqBody.HTML = rendered.Body
+	} else {
+		reqBody.Message = rendered.Body
+	}
+	return nil
 }
 
-func processMessage(d consumer_types.Delivery, ch consumer_types.Channel, em consumer_types.EmailSender) {
+func (c *Consumer) send(ctx context.Context, messageId string, reqBody types.RequestBody) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "send "+reqBody.ChannelName(), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(tracing.MessageID(messageId)))
+	defer func() { tracing.End(span, err) }()
+	provider, err := c.Providers.Get(reqBody.ChannelName())
+	if err != nil {
+		return err
+	}
+	if err := c.render(ctx, &reqBody); err != nil {
+		return err
+	}
+	start := time.Now()
+	err = provider.Send(ctx, messageId, reqBody)
+	metrics.Since(metrics.SendDuration, start, reqBody.ChannelName(), metrics.Result(err))
+	return err
+}
+
+func (c *Consumer) processMessage(d consumer_types.Delivery, ch consumer_types.Channel) {
 	retryCount := getRetryCount(d.Headers())
+	countMessage("processed", retryCount+1)
+	ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), d.Headers()), "process notification",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.Int("notify.retry_count", retryCount)))
+	defer span.End()
 	var reqBody types.RequestBody
 	log.Debugf("Message received from consumer or retry_queue: %s", d.Body())
 	err := json.Unmarshal(d.Body(), &reqBody)
 	logs.LogError(err, "Error with unmarshalling json")
 	if err != nil {
-		_ = d.Nack(false, false)
+		c.deadLetter(ch, d, d.Body(), &consumer_types.MalformedMessageError{Err: err})
+		countMessage("nacked", retryCount+1)
+		c.setStatus(d, status.DeadLettered)
 		return
 	}
-	err = em.SendEmail(reqBody.Email, reqBody.Message, reqBody.Subject)
-	logs.LogError(err, "Failed to send email")
+	if sender, _ := d.Headers()[auth.SenderHeader].(string); sender != "" {
+		// The sender identity of the API key wins over the body's from.
+		reqBody.From = sender
+	}
+	body := d.Body()
+	if reqBody.ChannelName() == types.ChannelEmail {
+		var ok bool
+		reqBody, body, ok = c.handleInvalidRecipients(ch, d, reqBody, retryCount)
+		if !ok {
+			return
+		}
+	}
+	c.setStatus(d, status.Sending)
+	err = c.send(ctx, d.MessageId(), reqBody)
+	logs.LogError(err, "Failed to send notification")
 	if err != nil {
-		if _, ok := err.(*consumer_types.InvalidEmailError); ok {
-			nackErr := d.Nack(false, false)
-			logs.LogError(nackErr, "Failed to nack on invalid email")
-		} else {
-			retry(ch, d, retryCount+1)
+		switch consumer_types.ErrorClass(err) {
+		case consumer_types.ErrorClassPermanent:
+			c.deadLetter(ch, d, body, err)
+			countMessage("nacked", retryCount+1)
+			c.setStatus(d, status.DeadLettered)
+		case consumer_types.ErrorClassRateLimited:
+			c.parkRateLimited(ch, d, retryCount, body, err)
+		default:
+			c.retryWithBody(ch, d, retryCount+1, body, err)
 		}
 		return
 	}
-	log.Debugf("The message recipient is: %s and the message is: %s", reqBody.Email, reqBody.Message)
+	log.Debugf("The %s notification was sent and the message is: %s", reqBody.ChannelName(), reqBody.Message)
 	err = d.Ack(false)
 	logs.LogError(err, "Not able to acknowledge:")
 	if err != nil {
 		err = d.Nack(false, true)
 		logs.LogError(err, "Failed to nack")
+		countMessage("nacked", retryCount+1)
 		return
 	}
+	countMessage("acked", retryCount+1)
+	c.setStatus(d, status.Delivered)
 }
 
-func workerConsumeAndProcessMessage(ch *amqp.Channel, id int, em consumer_types.EmailSender) {
-	err := ch.Qos(1, 0, false)
-	logs.LogError(err, "Failed to set qos for channel")
-	msgs, err := ch.Consume(
-		constants.MainQueueName,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	logs.FailOnError(err, "Failed to read messages")
+// consume cancels the consumer with basic.cancel once ctx is done, so the
+// broker stops delivering while in-flight messages are finished.
+func consume(ctx context.Context, ch *amqp.Channel, queue string, tag string) (<-chan amqp.Delivery, error) {
+	msgs, err := ch.Consume(queue, tag, false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		select {
+		case <-ctx.Done():
+			err := ch.Cancel(tag, false)
+			logs.LogError(err, "Failed to cancel consumer "+tag)
+		case <-closed:
+		}
+	}()
+	return msgs, nil
+}
+
+func (c *Consumer) workerConsumeAndProcessMessage(ctx context.Context, ch *amqp.Channel, id int) error {
+	err := ch.Qos(c.Prefetch, 0, false)
+	if err != nil {
+		return err
+	}
+	msgs, err := consume(ctx, ch, constants.MainQueueName, fmt.Sprintf("worker-%d", id))
+	if err != nil {
+		return err
+	}
+	metrics.ActiveWorkers.Inc()
+	defer metrics.ActiveWorkers.Dec()
 	for d := range msgs {
+		if ctx.Err() != nil {
+			err = d.Nack(false, true)
+			logs.LogError(err, "Failed to requeue message on shutdown")
+			continue
+		}
 		log.Debugf("Worker %d: Started processing message", id)
-		processMessage(consumer_types.NewDeliveryAdapter(d), ch, em)
+		metrics.InFlight.Inc()
+		c.processMessage(consumer_types.NewDeliveryAdapter(d), ch)
+		metrics.InFlight.Dec()
 		log.Debugf("Worker %d: Finished processing message", id)
 	}
+	return nil
 }
 
-func worker(id int, conn *amqp.Connection, wg *sync.WaitGroup, em consumer_types.EmailSender) {
-	ch := util.CreateChannel(conn)
-	defer ch.Close()
+func (c *Consumer) worker(ctx context.Context, id int, conns *connection.Manager, wg *sync.WaitGroup) {
 	defer wg.Done()
-	workerConsumeAndProcessMessage(ch, id, em)
+	superviseChannel(ctx, conns, fmt.Sprintf("Worker %d", id), func(ch *amqp.Channel) error {
+		return c.workerConsumeAndProcessMessage(ctx, ch, id)
+	})
 }
 
-func dlqConsumeAndProcessMessages(ch *amqp.Channel, id int, db *pgx.Conn) {
+func dlqConsumeAndProcessMessages(ctx context.Context, ch *amqp.Channel, id int, db consumer_types.DBExecutor) error {
 	err := ch.Qos(1, 0, false)
-	logs.LogError(err, "Failed to set qos for channel")
+	if err != nil {
+		return err
+	}
 	f, err := os.OpenFile("nack.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	dlqMsgs, err := ch.Consume(constants.DLQName, "", false, false, false, false, nil)
-	logs.FailOnError(err, "Failed to read messages")
 	logs.LogError(err, "Failed to open log file")
+	if err == nil {
+		defer f.Close()
+	}
+	dlqMsgs, err := consume(ctx, ch, constants.DLQName, fmt.Sprintf("dlq-worker-%d", id))
+	if err != nil {
+		return err
+	}
 	for d := range dlqMsgs {
+		if ctx.Err() != nil {
+			err = d.Nack(false, true)
+			logs.LogError(err, "Failed to requeue DLQ message on shutdown")
+			continue
+		}
 		log.Debugf("DLQ Worker %d: Started processing message", id)
 		err = processDLQMessage(consumer_types.NewDeliveryAdapter(d), f, db)
 		logs.LogError(err, "Error with processDLQMessage")
@@ -158,27 +458,64 @@ func dlqConsumeAndProcessMessages(ch *amqp.Channel, id int, db *pgx.Conn) {
 			log.Debugf("DLQ Worker %d: Finished processing message", id)
 		}
 	}
+	return nil
 }
 
-func dlqWorker(id int, conn *amqp.Connection, wg *sync.WaitGroup, db *pgx.Conn) {
-	ch := util.CreateChannel(conn)
-	defer ch.Close()
+func dlqWorker(ctx context.Context, id int, conns *connection.Manager, wg *sync.WaitGroup, db consumer_types.DBExecutor) {
 	defer wg.Done()
-	dlqConsumeAndProcessMessages(ch, id, db)
+	superviseChannel(ctx, conns, fmt.Sprintf("DLQ Worker %d", id), func(ch *amqp.Channel) error {
+		return dlqConsumeAndProcessMessages(ctx, ch, id, db)
+	})
 }
 
-func processDLQMessage(d consumer_types.Delivery, f *os.File, db consumer_types.DBExecutor) error {
+// superviseChannel runs fn on a fresh channel until ctx is done or the manager
+// is closed, reopening the channel whenever fn returns, e.g. after a broker
+// restart.
+func superviseChannel(ctx context.Context, conns *connection.Manager, name string, fn func(ch *amqp.Channel) error) {
+	for {
+		conn, err := conns.Connection(ctx)
+		if err != nil {
+			return
+		}
+		ch, err := conn.Channel()
+		if err == nil {
+			err = fn(ch)
+			ch.Close()
+		}
+		if ctx.Err() != nil || conns.State() == connection.StateClosed {
+			return
+		}
+		if err != nil {
+			logs.LogError(err, name+": channel failed, restarting")
+			time.Sleep(channelRestartDelay)
+			continue
+		}
+		log.Warnf("%s: channel closed, restarting", name)
+	}
+}
+
+func processDLQMessage(d consumer_types.Delivery, f *os.File, db consumer_types.DBExecutor) (err error) {
+	_, span := tracing.Tracer().Start(tracing.Extract(context.Background(), d.Headers()), "store dead letter",
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(tracing.MessageID(d.MessageId())))
+	defer func() { tracing.End(span, err) }()
 	bodyJson := d.Body()
 	headersMap := make(map[string]interface{})
 	for k, v := range d.Headers() {
 		headersMap[k] = v
 	}
-	_, err := db.Exec(context.Background(),
-		`INSERT INTO dlq_messages (message_id, body, headers) VALUES ($1, $2, $3)`,
+	meta := parseFailure(d.Headers(), time.Now())
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO dlq_messages (message_id, body, headers, reason, error_class, attempts, first_seen_at, original_queue) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 		d.MessageId(),
 		bodyJson,
 		headersMap,
+		meta.Reason,
+		meta.ErrorClass,
+		meta.Attempts,
+		meta.FirstSeen,
+		meta.OriginalQueue,
 	)
+	metrics.DLQInserts.WithLabelValues(metrics.Result(err)).Inc()
 	if err != nil {
 		logs.LogError(err, "Failed to insert DLQ message into DB")
 		_ = d.Nack(false, true)
@@ -193,14 +530,37 @@ func processDLQMessage(d consumer_types.Delivery, f *os.File, db consumer_types.
 	return nil
 }
 
-func StartWorkers(conn *amqp.Connection, emailSender consumer_types.EmailSender, db *pgx.Conn) {
-	numWorkers := 5
+// StartWorkers blocks until ctx is done and the workers have drained, or
+// ShutdownTimeout has passed. Messages still unacked at that point are
+// requeued by the broker once the caller closes the connection.
+func (c *Consumer) StartWorkers(ctx context.Context, conns *connection.Manager, db consumer_types.DBExecutor) {
+	numWorkers := c.Workers
 	var wg sync.WaitGroup
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go worker(i, conn, &wg, emailSender)
+		go c.worker(ctx, i, conns, &wg)
 	}
 	wg.Add(1)
-	go dlqWorker(numWorkers+1, conn, &wg, db)
-	wg.Wait()
+	go dlqWorker(ctx, numWorkers+1, conns, &wg, db)
+	if c.Schedule != nil {
+		wg.Add(1)
+		go c.scheduler(ctx, conns, &wg)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	log.Info("Shutting down: waiting for in-flight messages")
+	select {
+	case <-done:
+		log.Info("All workers stopped")
+	case <-time.After(c.ShutdownTimeout):
+		log.Warnf("Workers still busy after %s, unacked messages will be requeued", c.ShutdownTimeout)
+	}
 }
@@ -2,16 +2,35 @@ package consumer
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgconn"
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
 	constants "github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	"github.com/jayanth-parthsarathy/notify/internal/common/metrics"
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	"github.com/jayanth-parthsarathy/notify/internal/common/retry"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status/statustest"
+	"github.com/jayanth-parthsarathy/notify/internal/common/templates"
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
 	consumer_types "github.com/jayanth-parthsarathy/notify/internal/consumer/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 func TestGetRetryCount(t *testing.T) {
@@ -24,14 +43,6 @@ func TestGetRetryCount(t *testing.T) {
 	assert.Equal(0, getRetryCount(amqp.Table{"x-retry-count": "bad"}))
 }
 
-func TestGetRetryQueueName(t *testing.T) {
-	assert := assert.New(t)
-	assert.Equal(constants.Retry10sQueue, getRetryQueueName(1), "Should return 10s retry queue")
-	assert.Equal(constants.Retry30sQueue, getRetryQueueName(2), "Should return 30s retry queue")
-	assert.Equal(constants.Retry60sQueue, getRetryQueueName(3), "Should return 60s retry queue")
-	assert.Equal("", getRetryQueueName(4), "Should return empty string for invalid retry count")
-}
-
 func TestPopulateHeader(t *testing.T) {
 	assert := assert.New(t)
 
@@ -101,7 +112,8 @@ func TestRetry_MaxRetries(t *testing.T) {
 	msg.On("Nack", false, false).Return(nil)
 	msg.On("Body").Return(body)
 
-	retry(ch, msg, 3)
+	consumer := &Consumer{Policy: retry.DefaultPolicy()}
+	consumer.retry(ch, msg, 4)
 
 	msg.AssertCalled(t, "Nack", false, false)
 	ch.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
@@ -133,7 +145,7 @@ func TestRetry_SingleRetry(t *testing.T) {
 
 	ch.On("Publish",
 		constants.RetryExchangeName,
-		constants.Retry10sQueue,
+		"retry-10s",
 		false, false,
 		mock.MatchedBy(func(p amqp.Publishing) bool {
 			got := getRetryCount(p.Headers)
@@ -143,7 +155,8 @@ func TestRetry_SingleRetry(t *testing.T) {
 		}),
 	).Return(nil)
 
-	retry(ch, msg, 1)
+	consumer := &Consumer{Policy: retry.DefaultPolicy()}
+	consumer.retry(ch, msg, 1)
 
 	msg.AssertCalled(t, "Ack", false)
 	msg.AssertNotCalled(t, "Nack", mock.Anything)
@@ -155,11 +168,43 @@ type MockEmailSender struct {
 	mock.Mock
 }
 
-func (m *MockEmailSender) SendEmail(recipient string, body string, subject string) error {
-	args := m.Called(recipient, body, subject)
+func (m *MockEmailSender) SendEmail(email consumer_types.Email) error {
+	args := m.Called(strings.Join(email.To, ","), email.Text, email.Subject)
 	return args.Error(0)
 }
 
+func emailRegistry(em consumer_types.EmailSender) *consumer_types.Registry {
+	registry := consumer_types.NewRegistry()
+	registry.Register(types.ChannelEmail, &consumer_types.EmailProvider{Sender: em})
+	return registry
+}
+
+type emailSenderFunc func(consumer_types.Email) error
+
+func (f emailSenderFunc) SendEmail(email consumer_types.Email) error {
+	return f(email)
+}
+
+func TestProcessMessage_KeySenderOverridesFrom(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com","from":"ceo@example.com","message":"hello"}`))
+	d.On("Headers").Return(amqp.Table{auth.SenderHeader: "billing@example.com"})
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	var from string
+	em := emailSenderFunc(func(email consumer_types.Email) error {
+		from = email.From
+		return nil
+	})
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	d.AssertCalled(t, "Ack", false)
+	assert.Equal(t, "billing@example.com", from)
+}
+
 func TestProcessMessage_MalformedJSON(t *testing.T) {
 	d := new(MockDelivery)
 	ch := new(MockChannel)
@@ -167,13 +212,20 @@ func TestProcessMessage_MalformedJSON(t *testing.T) {
 
 	d.On("Body").Return([]byte("not-json"))
 	d.On("Headers").Return(amqp.Table(nil))
-	d.On("Nack", false, false).Return(nil)
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return string(p.Body) == "not-json" &&
+			p.Headers[constants.ErrorClassHeader] == consumer_types.ErrorClassPermanent &&
+			strings.HasPrefix(p.Headers[constants.LastErrorHeader].(string), "malformed message")
+	})).Return(nil)
 
-	processMessage(d, ch, em)
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
 
-	d.AssertCalled(t, "Nack", false, false)
-	d.AssertNotCalled(t, "Ack", mock.Anything)
-	ch.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	ch.AssertExpectations(t)
+	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
 	em.AssertNotCalled(t, "SendEmail", mock.Anything, mock.Anything, mock.Anything)
 }
 
@@ -185,10 +237,12 @@ func TestProcessMessage_Success(t *testing.T) {
 	valid := `{"email":"foo@bar.com","message":"hello", "subject": "hello world"}`
 	d.On("Body").Return([]byte(valid))
 	d.On("Headers").Return(amqp.Table(nil))
+	d.On("MessageId").Return("")
 	d.On("Ack", false).Return(nil)
 	em.On("SendEmail", "foo@bar.com", "hello", "hello world").Return(nil)
 
-	processMessage(d, ch, em)
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
 
 	d.AssertCalled(t, "Ack", false)
 	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
@@ -209,7 +263,7 @@ func TestProcessMessage_FailureRetries(t *testing.T) {
 	d.On("Ack", false).Return(nil)
 	ch.On("Publish",
 		constants.RetryExchangeName,
-		constants.Retry10sQueue,
+		"retry-10s",
 		false, false,
 		mock.MatchedBy(func(pub amqp.Publishing) bool {
 			v := getRetryCount(pub.Headers)
@@ -218,7 +272,8 @@ func TestProcessMessage_FailureRetries(t *testing.T) {
 	).Return(nil)
 	em.On("SendEmail", "foo@bar.com", "hello", "hello world").Return(errors.New("This is testing error"))
 
-	processMessage(d, ch, em)
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
 
 	ch.AssertCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	em.AssertCalled(t, "SendEmail", "foo@bar.com", "hello", "hello world")
@@ -235,24 +290,17 @@ func TestProcessMessage_FailureInvalidEmail(t *testing.T) {
 	d.On("Body").Return([]byte(valid))
 	d.On("Headers").Return(amqp.Table{"x-retry-count": int32(0)})
 	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
 	d.On("Ack", false).Return(nil)
-	d.On("Nack", false, false).Return(nil)
-	ch.On("Publish",
-		constants.RetryExchangeName,
-		constants.Retry10sQueue,
-		false, false,
-		mock.MatchedBy(func(pub amqp.Publishing) bool {
-			v := getRetryCount(pub.Headers)
-			return v == 1
-		}),
-	).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.Anything).Return(nil)
 	em.On("SendEmail", "foo", "hello", "hello world").Return(&consumer_types.InvalidEmailError{Email: "foo", Message: "Invalid email sending it to DLQ"})
 
-	processMessage(d, ch, em)
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
 
-	ch.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
-	em.AssertCalled(t, "SendEmail", "foo", "hello", "hello world")
-	d.AssertCalled(t, "Nack", false, false)
+	ch.AssertExpectations(t)
+	ch.AssertNotCalled(t, "Publish", constants.RetryExchangeName, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	em.AssertNotCalled(t, "SendEmail", "foo", "hello", "hello world")
 }
 
 func TestProcessDLQMessage_AckSuccess(t *testing.T) {
@@ -275,6 +323,31 @@ func TestProcessDLQMessage_AckSuccess(t *testing.T) {
 	mockD.AssertExpectations(t)
 }
 
+func TestProcessDLQMessage_StoresFailure(t *testing.T) {
+	d := new(MockDelivery)
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com"}`))
+	d.On("Headers").Return(amqp.Table{
+		"x-retry-count":               int32(3),
+		constants.LastErrorHeader:     "smtp down",
+		constants.ErrorClassHeader:    consumer_types.ErrorClassTransient,
+		constants.OriginalQueueHeader: constants.MainQueueName,
+	})
+	d.On("MessageId").Return("msg-1")
+	d.On("Ack", false).Return(nil)
+	db := new(MockDB)
+	db.On("Exec", mock.Anything, mock.MatchedBy(func(sql string) bool {
+		return strings.Contains(sql, "reason, error_class, attempts, first_seen_at, original_queue")
+	}), mock.MatchedBy(func(args []any) bool {
+		return len(args) == 8 && args[0] == "msg-1" && args[3] == "smtp down" &&
+			args[4] == consumer_types.ErrorClassTransient && args[5] == 4 && args[7] == constants.MainQueueName
+	})).Return(pgconn.CommandTag("INSERT 0 1"), nil)
+
+	err := processDLQMessage(d, nil, db)
+
+	assert.NoError(t, err)
+	db.AssertExpectations(t)
+}
+
 func TestProcessDLQMessage_AckFail(t *testing.T) {
 	mockD := new(MockDelivery)
 	mockD.On("MessageId").Return("123")
@@ -295,3 +368,468 @@ func TestProcessDLQMessage_AckFail(t *testing.T) {
 
 	mockD.AssertExpectations(t)
 }
+
+func TestProcessMessage_TracksStatus(t *testing.T) {
+	store := statustest.NewMemoryStore()
+
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com","message":"hello","subject":"hi"}`))
+	d.On("Headers").Return(amqp.Table(nil))
+	d.On("MessageId").Return("msg-1")
+	d.On("Ack", false).Return(nil)
+	em.On("SendEmail", "foo@bar.com", "hello", "hi").Return(nil)
+
+	consumer := &Consumer{Providers: emailRegistry(em), Status: store, Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	st, err := store.Get(context.Background(), "msg-1")
+	assert.NoError(t, err)
+	assert.Equal(t, status.Delivered, st.Status)
+}
+
+func TestRetry_TracksStatus(t *testing.T) {
+	store := statustest.NewMemoryStore()
+	ctx := context.Background()
+
+	ch := new(MockChannel)
+	msg := new(MockDelivery)
+	msg.On("Body").Return([]byte("payload"))
+	msg.On("Headers").Return(amqp.Table{})
+	msg.On("ContentType").Return("application/json")
+	msg.On("MessageId").Return("msg-2")
+	msg.On("Ack", false).Return(nil)
+	msg.On("Nack", false, false).Return(nil)
+	ch.On("Publish", mock.Anything, mock.Anything, false, false, mock.Anything).Return(nil)
+
+	consumer := &Consumer{Status: store, Policy: retry.DefaultPolicy()}
+	consumer.retry(ch, msg, 2)
+	st, err := store.Get(ctx, "msg-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "retrying(2)", st.Status)
+
+	consumer.retry(ch, msg, 4)
+	st, err = store.Get(ctx, "msg-2")
+	assert.NoError(t, err)
+	assert.Equal(t, status.DeadLettered, st.Status)
+}
+
+func TestRetry_RoutesByPolicy(t *testing.T) {
+	ch := new(MockChannel)
+	msg := new(MockDelivery)
+	msg.On("Body").Return([]byte("payload"))
+	msg.On("Headers").Return(amqp.Table{})
+	msg.On("ContentType").Return("application/json")
+	msg.On("MessageId").Return("")
+	msg.On("Ack", false).Return(nil)
+	ch.On("Publish", constants.RetryExchangeName, "retry-20s", false, false,
+		mock.MatchedBy(func(p amqp.Publishing) bool {
+			expiration, err := strconv.Atoi(p.Expiration)
+			return err == nil && expiration > 10000 && expiration <= 20000
+		}),
+	).Return(nil)
+
+	policy := retry.Policy{MaxAttempts: 5, InitialDelay: 5 * time.Second, Multiplier: 2, MaxDelay: 20 * time.Second, Jitter: 0.5}
+	consumer := &Consumer{Policy: policy}
+	consumer.retry(ch, msg, 4)
+
+	ch.AssertExpectations(t)
+	msg.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessage_UnsupportedChannelDeadLetters(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"channel":"pager","message":"hello"}`))
+	d.On("Headers").Return(amqp.Table(nil))
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return p.Headers[constants.ErrorClassHeader] == consumer_types.ErrorClassPermanent
+	})).Return(nil)
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+	d.AssertCalled(t, "Ack", false)
+	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
+	em.AssertNotCalled(t, "SendEmail", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessage_DispatchesByChannel(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+	d.On("Body").Return([]byte(`{"channel":"webhook","url":"` + server.URL + `","message":"deployed"}`))
+	d.On("Headers").Return(amqp.Table(nil))
+	d.On("MessageId").Return("msg-1")
+	d.On("Ack", false).Return(nil)
+
+	registry := emailRegistry(em)
+	registry.Register(types.ChannelWebhook, &consumer_types.WebhookProvider{Client: server.Client()})
+	consumer := &Consumer{Providers: registry, Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	d.AssertCalled(t, "Ack", false)
+	assert.Equal(t, "msg-1", received["message_id"])
+	assert.Equal(t, "deployed", received["message"])
+	em.AssertNotCalled(t, "SendEmail", mock.Anything, mock.Anything, mock.Anything)
+}
+
+type MockTemplateStore struct {
+	templates.Store
+	mock.Mock
+}
+
+func (m *MockTemplateStore) Get(ctx context.Context, id string, version int) (templates.Template, error) {
+	args := m.Called(ctx, id, version)
+	return args.Get(0).(templates.Template), args.Error(1)
+}
+
+func TestProcessMessage_RendersTemplate(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+	store := new(MockTemplateStore)
+
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com","template_id":"welcome","data":{"name":"Ada"}}`))
+	d.On("Headers").Return(amqp.Table(nil))
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	store.On("Get", mock.Anything, "welcome", 0).
+		Return(templates.Template{ID: "welcome", Version: 1, Subject: "Hi {{.name}}", Body: "Welcome, {{.name}}!", Format: templates.FormatText}, nil)
+	em.On("SendEmail", "foo@bar.com", "Welcome, Ada!", "Hi Ada").Return(nil)
+
+	consumer := &Consumer{Providers: emailRegistry(em), Templates: store, Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	d.AssertCalled(t, "Ack", false)
+	em.AssertExpectations(t)
+}
+
+func TestProcessMessage_MissingTemplateDeadLetters(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+	store := new(MockTemplateStore)
+
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com","template_id":"gone","template_version":3}`))
+	d.On("Headers").Return(amqp.Table(nil))
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.Anything).Return(nil)
+	store.On("Get", mock.Anything, "gone", 3).Return(templates.Template{}, templates.ErrNotFound)
+
+	consumer := &Consumer{Providers: emailRegistry(em), Templates: store, Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
+	em.AssertNotCalled(t, "SendEmail", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessage_SendValidDeadLettersInvalidRecipients(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"email":"a@bar.com","to":["bad","b@bar.com"],"cc":["also bad"],"message":"hello","subject":"hi","on_invalid_recipients":"send_valid"}`))
+	d.On("Headers").Return(amqp.Table{"x-trace": "1"})
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("msg-1")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		var rejected types.RequestBody
+		if err := json.Unmarshal(p.Body, &rejected); err != nil {
+			return false
+		}
+		reasons := p.Headers["x-invalid-recipients"].([]interface{})
+		return p.MessageId == "msg-1" && p.Headers["x-trace"] == "1" &&
+			assert.ObjectsAreEqual([]string{"bad"}, rejected.To) &&
+			assert.ObjectsAreEqual([]string{"also bad"}, rejected.Cc) &&
+			len(reasons) == 2 && strings.HasPrefix(reasons[0].(string), "to[0]") && strings.HasPrefix(reasons[1].(string), "cc[0]")
+	})).Return(nil)
+	em.On("SendEmail", "a@bar.com,b@bar.com", "hello", "hi").Return(nil)
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+	em.AssertExpectations(t)
+	d.AssertCalled(t, "Ack", false)
+	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessage_SendValidRetriesOnlyValidRecipients(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"to":["bad","b@bar.com"],"message":"hello","subject":"hi","on_invalid_recipients":"send_valid"}`))
+	d.On("Headers").Return(amqp.Table{})
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.Anything).Return(nil)
+	ch.On("Publish", constants.RetryExchangeName, "retry-10s", false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		var retried types.RequestBody
+		return json.Unmarshal(p.Body, &retried) == nil && assert.ObjectsAreEqual([]string{"b@bar.com"}, retried.To)
+	})).Return(nil)
+	em.On("SendEmail", "b@bar.com", "hello", "hi").Return(errors.New("smtp down"))
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+}
+
+func TestProcessMessage_RejectPolicyDeadLettersWholeMessage(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"to":["a@bar.com"],"bcc":["bad"],"message":"hello"}`))
+	d.On("Headers").Return(amqp.Table(nil))
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return string(p.Body) == `{"to":["a@bar.com"],"bcc":["bad"],"message":"hello"}` &&
+			strings.HasPrefix(p.Headers[constants.LastErrorHeader].(string), "bcc[0]")
+	})).Return(nil)
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
+	em.AssertNotCalled(t, "SendEmail", mock.Anything, mock.Anything, mock.Anything)
+}
+
+type fakeScheduleStore struct {
+	schedule.Store
+	due []schedule.Notification
+}
+
+func (f *fakeScheduleStore) ReleaseDue(_ context.Context, now time.Time, limit int, publish func(schedule.Notification) error) (int, error) {
+	released := 0
+	for _, n := range f.due {
+		if n.SendAt.After(now) || released == limit {
+			break
+		}
+		if err := publish(n); err != nil {
+			return released, err
+		}
+		released++
+	}
+	f.due = f.due[released:]
+	return released, nil
+}
+
+type MockConfirmChannel struct {
+	mock.Mock
+}
+
+func (m *MockConfirmChannel) PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
+	args := m.Called(exchange, key, mandatory, immediate, msg)
+	return args.Error(0)
+}
+
+func TestReleaseDue_PublishesToMainQueue(t *testing.T) {
+	now := time.Date(2025, 6, 10, 9, 0, 0, 0, time.UTC)
+	store := &fakeScheduleStore{due: []schedule.Notification{
+		{MessageId: "msg-1", Body: []byte(`{"email":"a@b.com"}`), Headers: amqp.Table{auth.SenderHeader: "billing@example.com"}, SendAt: now.Add(-time.Second)},
+		{MessageId: "msg-2", Body: []byte(`{"email":"c@d.com"}`), SendAt: now.Add(time.Hour)},
+	}}
+	statusStore := statustest.NewMemoryStore()
+	ch := new(MockConfirmChannel)
+	ch.On("PublishWithContext", "", constants.MainQueueName, true, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return p.MessageId == "msg-1" && string(p.Body) == `{"email":"a@b.com"}` && p.DeliveryMode == amqp.Persistent &&
+			p.Headers[auth.SenderHeader] == "billing@example.com"
+	})).Run(func(mock.Arguments) {
+		st, err := statusStore.Get(context.Background(), "msg-1")
+		assert.NoError(t, err)
+		assert.Equal(t, status.Queued, st.Status, "status must be queued before the publish")
+		// A worker picks the message up straight away.
+		statusStore.Set(context.Background(), "msg-1", status.Delivered)
+	}).Return(nil)
+
+	consumer := &Consumer{Schedule: store, Status: statusStore}
+	released, err := consumer.releaseDue(context.Background(), ch, now)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	ch.AssertExpectations(t)
+	st, err := statusStore.Get(context.Background(), "msg-1")
+	assert.NoError(t, err)
+	assert.Equal(t, status.Delivered, st.Status)
+	assert.Len(t, store.due, 1)
+}
+
+func TestReleaseDue_ContinuesTrace(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	now := time.Date(2025, 6, 10, 9, 0, 0, 0, time.UTC)
+	headers := amqp.Table{"traceparent": "00-" + traceId + "-00f067aa0ba902b7-01"}
+	store := &fakeScheduleStore{due: []schedule.Notification{
+		{MessageId: "msg-1", Body: []byte(`{"email":"a@b.com"}`), Headers: headers, SendAt: now.Add(-time.Second)},
+	}}
+	ch := new(MockConfirmChannel)
+	ch.On("PublishWithContext", "", constants.MainQueueName, true, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		traceparent, _ := p.Headers["traceparent"].(string)
+		return strings.Contains(traceparent, traceId)
+	})).Return(nil)
+
+	consumer := &Consumer{Schedule: store}
+	released, err := consumer.releaseDue(context.Background(), ch, now)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	ch.AssertExpectations(t)
+}
+
+func TestReleaseDue_KeepsUnconfirmedNotifications(t *testing.T) {
+	now := time.Date(2025, 6, 10, 9, 0, 0, 0, time.UTC)
+	store := &fakeScheduleStore{due: []schedule.Notification{
+		{MessageId: "msg-1", Body: []byte(`{"email":"a@b.com"}`), SendAt: now.Add(-time.Second)},
+	}}
+	statusStore := statustest.NewMemoryStore()
+	statusStore.Set(context.Background(), "msg-1", status.Scheduled)
+	ch := new(MockConfirmChannel)
+	ch.On("PublishWithContext", "", constants.MainQueueName, true, false, mock.Anything).Return(connection.ErrNacked)
+
+	consumer := &Consumer{Schedule: store, Status: statusStore}
+	released, err := consumer.releaseDue(context.Background(), ch, now)
+
+	assert.ErrorIs(t, err, connection.ErrNacked)
+	assert.Equal(t, 0, released)
+	assert.Len(t, store.due, 1)
+	st, err := statusStore.Get(context.Background(), "msg-1")
+	assert.NoError(t, err)
+	assert.Equal(t, status.Scheduled, st.Status)
+}
+
+func TestProcessMessage_RecordsMetrics(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com","message":"hello","subject":"hello world"}`))
+	d.On("Headers").Return(amqp.Table{"x-retry-count": int32(0)})
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", constants.RetryExchangeName, "retry-10s", false, false, mock.Anything).Return(nil)
+	em.On("SendEmail", "foo@bar.com", "hello", "hello world").Return(errors.New("smtp down"))
+
+	processed := testutil.ToFloat64(metrics.Messages.WithLabelValues("processed", "1"))
+	retried := testutil.ToFloat64(metrics.Messages.WithLabelValues("retried", "1"))
+	parked := testutil.ToFloat64(metrics.Retries.WithLabelValues("retry-10s"))
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	assert.Equal(t, processed+1, testutil.ToFloat64(metrics.Messages.WithLabelValues("processed", "1")))
+	assert.Equal(t, retried+1, testutil.ToFloat64(metrics.Messages.WithLabelValues("retried", "1")))
+	assert.Equal(t, parked+1, testutil.ToFloat64(metrics.Retries.WithLabelValues("retry-10s")))
+}
+
+func TestProcessMessage_PermanentSMTPFailureSkipsRetries(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"email":"gone@bar.com","message":"hello","subject":"hi"}`))
+	d.On("Headers").Return(amqp.Table{"x-retry-count": int32(0), "traceparent": "00-abc"})
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("msg-1")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return p.MessageId == "msg-1" && p.Headers["traceparent"] == "00-abc" &&
+			p.Headers[constants.ErrorClassHeader] == consumer_types.ErrorClassPermanent
+	})).Return(nil)
+	em.On("SendEmail", "gone@bar.com", "hello", "hi").Return(&consumer_types.PermanentSMTPError{SMTPError: consumer_types.SMTPError{Code: 550, Message: "5.1.1 mailbox unavailable"}})
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+	ch.AssertNotCalled(t, "Publish", constants.RetryExchangeName, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessage_RateLimitedRetriesWithClass(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com","message":"hello","subject":"hi"}`))
+	d.On("Headers").Return(amqp.Table{"x-retry-count": int32(0)})
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", constants.RetryExchangeName, "retry-10s", false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return p.Headers[constants.ErrorClassHeader] == consumer_types.ErrorClassRateLimited &&
+			p.Headers["x-retry-count"] == int32(0)
+	})).Return(nil)
+	em.On("SendEmail", "foo@bar.com", "hello", "hi").Return(&consumer_types.RateLimitedSMTPError{SMTPError: consumer_types.SMTPError{Code: 421, Message: "4.7.0 Try again later"}})
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+}
+
+func TestProcessMessage_RateLimitedWaitsRetryAfter(t *testing.T) {
+	d := new(MockDelivery)
+	ch := new(MockChannel)
+	em := new(MockEmailSender)
+
+	d.On("Body").Return([]byte(`{"email":"foo@bar.com","message":"hello","subject":"hi"}`))
+	d.On("Headers").Return(amqp.Table{"x-retry-count": int32(3)})
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", constants.RetryExchangeName, "retry-30s", false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return p.Expiration == "25000" && p.Headers["x-retry-count"] == int32(3)
+	})).Return(nil)
+	em.On("SendEmail", "foo@bar.com", "hello", "hi").Return(&ratelimit.LimitedError{RetryAfter: 25 * time.Second})
+
+	consumer := &Consumer{Providers: emailRegistry(em), Policy: retry.DefaultPolicy()}
+	consumer.processMessage(d, ch)
+
+	ch.AssertExpectations(t)
+	ch.AssertNotCalled(t, "Publish", "", constants.DLQName, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRetry_ExhaustedRecordsClass(t *testing.T) {
+	ch := new(MockChannel)
+	d := new(MockDelivery)
+
+	d.On("Headers").Return(amqp.Table{"x-retry-count": int32(3)})
+	d.On("ContentType").Return("application/json")
+	d.On("MessageId").Return("")
+	d.On("Ack", false).Return(nil)
+	ch.On("Publish", "", constants.DLQName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return string(p.Body) == "payload" && p.Headers[constants.ErrorClassHeader] == consumer_types.ErrorClassTransient
+	})).Return(nil)
+
+	consumer := &Consumer{Policy: retry.DefaultPolicy()}
+	consumer.retryWithBody(ch, d, 4, []byte("payload"), errors.New("connection reset"))
+
+	ch.AssertExpectations(t)
+	d.AssertNotCalled(t, "Nack", mock.Anything, mock.Anything)
+}
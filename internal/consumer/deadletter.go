@@ -0,0 +1,91 @@
+package consumer
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	constants "github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	consumer_types "github.com/jayanth-parthsarathy/notify/internal/consumer/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recordFailure notes a failed attempt in headers: the error and its class,
+// and an entry in the attempt history that travels with the message through
+// the retry queues into the DLQ.
+func recordFailure(headers amqp.Table, cause error, now time.Time) {
+	class := consumer_types.ErrorClass(cause)
+	headers[constants.ErrorClassHeader] = class
+	headers[constants.LastErrorHeader] = cause.Error()
+	history, _ := headers[constants.AttemptsHeader].([]interface{})
+	headers[constants.AttemptsHeader] = append(slices.Clone(history), amqp.Table{
+		"at":    now.UTC(),
+		"class": class,
+		"error": cause.Error(),
+	})
+}
+
+type failure struct {
+	Reason        string
+	ErrorClass    string
+	Attempts      int
+	FirstSeen     time.Time
+	OriginalQueue string
+}
+
+// parseFailure summarises the headers of a dead-lettered message. Messages
+// nacked by the broker carry no failure headers of ours, so it falls back to
+// x-death, skipping the "expired" entries left by the retry queues.
+func parseFailure(headers amqp.Table, now time.Time) failure {
+	f := failure{Attempts: getRetryCount(headers) + 1, FirstSeen: now}
+	f.Reason, _ = headers[constants.LastErrorHeader].(string)
+	f.ErrorClass, _ = headers[constants.ErrorClassHeader].(string)
+	f.OriginalQueue, _ = headers[constants.OriginalQueueHeader].(string)
+
+	history, _ := headers[constants.AttemptsHeader].([]interface{})
+	f.Attempts = max(f.Attempts, len(history))
+	for _, entry := range history {
+		if attempt, ok := entry.(amqp.Table); ok {
+			f.FirstSeen = earliest(f.FirstSeen, attempt["at"])
+		}
+	}
+
+	deaths, _ := headers["x-death"].([]interface{})
+	for _, entry := range deaths {
+		death, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+		f.FirstSeen = earliest(f.FirstSeen, death["time"])
+		reason, _ := death["reason"].(string)
+		if reason == "expired" {
+			continue
+		}
+		if f.OriginalQueue == "" {
+			f.OriginalQueue, _ = death["queue"].(string)
+		}
+		if f.Reason == "" {
+			f.Reason = reason
+		}
+	}
+
+	if f.Reason == "" {
+		if invalid, ok := headers["x-invalid-recipients"].([]interface{}); ok {
+			reasons := make([]string, 0, len(invalid))
+			for _, r := range invalid {
+				if s, ok := r.(string); ok {
+					reasons = append(reasons, s)
+				}
+			}
+			f.Reason = "invalid recipients: " + strings.Join(reasons, "; ")
+		}
+	}
+	return f
+}
+
+func earliest(current time.Time, candidate interface{}) time.Time {
+	if t, ok := candidate.(time.Time); ok && t.Before(current) {
+		return t
+	}
+	return current
+}
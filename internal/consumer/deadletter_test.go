@@ -0,0 +1,73 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	constants "github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	consumer_types "github.com/jayanth-parthsarathy/notify/internal/consumer/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordFailure_AppendsHistory(t *testing.T) {
+	first := time.Date(2025, 6, 14, 9, 0, 0, 0, time.UTC)
+	headers := amqp.Table{}
+
+	recordFailure(headers, errors.New("connection reset"), first)
+	recordFailure(headers, &consumer_types.PermanentSMTPError{SMTPError: consumer_types.SMTPError{Code: 550, Message: "5.1.1 unknown user"}}, first.Add(10*time.Second))
+
+	assert.Equal(t, consumer_types.ErrorClassPermanent, headers[constants.ErrorClassHeader])
+	assert.Equal(t, "smtp server replied 550: 5.1.1 unknown user", headers[constants.LastErrorHeader])
+	history := headers[constants.AttemptsHeader].([]interface{})
+	assert.Len(t, history, 2)
+	assert.Equal(t, amqp.Table{"at": first, "class": consumer_types.ErrorClassTransient, "error": "connection reset"}, history[0])
+	assert.NoError(t, headers.Validate())
+}
+
+func TestParseFailure(t *testing.T) {
+	now := time.Date(2025, 6, 14, 10, 0, 0, 0, time.UTC)
+	firstAttempt := now.Add(-2 * time.Minute)
+	firstDeath := now.Add(-time.Minute)
+
+	t.Run("recorded by the consumer", func(t *testing.T) {
+		headers := amqp.Table{
+			"x-retry-count":               int32(3),
+			constants.LastErrorHeader:     "smtp down",
+			constants.ErrorClassHeader:    consumer_types.ErrorClassTransient,
+			constants.OriginalQueueHeader: constants.MainQueueName,
+			constants.AttemptsHeader:      []interface{}{amqp.Table{"at": firstAttempt}, amqp.Table{"at": now}, amqp.Table{"at": now}, amqp.Table{"at": now}},
+			"x-death":                     []interface{}{amqp.Table{"queue": "retry-10s", "reason": "expired", "time": firstDeath}},
+		}
+
+		assert.Equal(t, failure{
+			Reason:        "smtp down",
+			ErrorClass:    consumer_types.ErrorClassTransient,
+			Attempts:      4,
+			FirstSeen:     firstAttempt,
+			OriginalQueue: constants.MainQueueName,
+		}, parseFailure(headers, now))
+	})
+
+	t.Run("nacked by the broker", func(t *testing.T) {
+		headers := amqp.Table{
+			"x-retry-count": int32(1),
+			"x-death": []interface{}{
+				amqp.Table{"queue": constants.MainQueueName, "reason": "rejected", "time": now},
+				amqp.Table{"queue": "retry-10s", "reason": "expired", "time": firstDeath},
+			},
+		}
+
+		assert.Equal(t, failure{Reason: "rejected", Attempts: 2, FirstSeen: firstDeath, OriginalQueue: constants.MainQueueName}, parseFailure(headers, now))
+	})
+
+	t.Run("invalid recipients", func(t *testing.T) {
+		headers := amqp.Table{"x-invalid-recipients": []interface{}{"to[0]: bad", "cc[0]: worse"}}
+
+		f := parseFailure(headers, now)
+		assert.Equal(t, "invalid recipients: to[0]: bad; cc[0]: worse", f.Reason)
+		assert.Equal(t, 1, f.Attempts)
+		assert.Equal(t, now, f.FirstSeen)
+	})
+}
@@ -0,0 +1,30 @@
+package consumer
+
+import (
+	"context"
+	"net/http"
+
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/metrics"
+	"github.com/jayanth-parthsarathy/notify/internal/common/util"
+)
+
+func (c *Consumer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	if c.Health != nil {
+		c.Health.Register(mux)
+	}
+	return mux
+}
+
+// Serve exposes the consumer's admin endpoints (metrics, liveness and
+// readiness) on Addr until ctx is done.
+func (c *Consumer) Serve(ctx context.Context) {
+	err := util.Serve(ctx, &http.Server{Addr: c.Addr, Handler: c.routes()}, c.ShutdownTimeout)
+	if ctx.Err() == nil {
+		logs.LogError(err, "Consumer HTTP server failed")
+		return
+	}
+	logs.LogError(err, "Consumer HTTP server did not shut down cleanly")
+}
@@ -0,0 +1,90 @@
+package consumer
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
+	constants "github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
+	consumer_types "github.com/jayanth-parthsarathy/notify/internal/consumer/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const schedulerBatchSize = 100
+
+// releaseDue publishes due notifications with publisher confirms. A
+// notification whose publish is not confirmed stays scheduled and is retried
+// on the next tick. The status is set to queued before publishing, as the
+// producer does, so it never overwrites one set by a worker. The publish
+// continues the trace of the request that scheduled the notification.
+func (c *Consumer) releaseDue(ctx context.Context, ch consumer_types.ConfirmChannel, now time.Time) (int, error) {
+	return c.Schedule.ReleaseDue(ctx, now, schedulerBatchSize, func(n schedule.Notification) (err error) {
+		spanCtx, span := tracing.Tracer().Start(tracing.Extract(ctx, n.Headers), "release scheduled notification",
+			trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(tracing.MessageID(n.MessageId)))
+		defer func() { tracing.End(span, err) }()
+		c.setScheduledStatus(ctx, n.MessageId, status.Queued)
+		err = ch.PublishWithContext(ctx, "", constants.MainQueueName, true, false, amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/json",
+			Body:         n.Body,
+			Headers:      tracing.Inject(spanCtx, maps.Clone(n.Headers)),
+			MessageId:    n.MessageId,
+		})
+		if err != nil {
+			c.setScheduledStatus(ctx, n.MessageId, status.Scheduled)
+		}
+		return err
+	})
+}
+
+func (c *Consumer) setScheduledStatus(ctx context.Context, messageId string, state string) {
+	if c.Status == nil {
+		return
+	}
+	err := c.Status.Set(ctx, messageId, state)
+	logs.LogError(err, "Failed to set notification status")
+}
+
+func (c *Consumer) scheduler(ctx context.Context, conns *connection.Manager, wg *sync.WaitGroup) {
+	defer wg.Done()
+	superviseChannel(ctx, conns, "Scheduler", func(ch *amqp.Channel) error {
+		return c.runScheduler(ctx, ch)
+	})
+}
+
+func (c *Consumer) runScheduler(ctx context.Context, ch *amqp.Channel) error {
+	confirmCh, err := connection.NewConfirmChannel(ch, c.ConfirmTimeout)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(c.SchedulerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		for {
+			released, err := c.releaseDue(context.Background(), confirmCh, time.Now())
+			if err != nil && ch.IsClosed() {
+				return err
+			}
+			logs.LogError(err, "Failed to release scheduled notifications")
+			if released > 0 {
+				log.Debugf("Scheduler: released %d scheduled notifications", released)
+			}
+			if err != nil || released < schedulerBatchSize {
+				break
+			}
+		}
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net/smtp"
-	"os"
+	"time"
 
 	"github.com/jackc/pgconn"
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
 	consumer_util "github.com/jayanth-parthsarathy/notify/internal/consumer/util"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -15,6 +16,11 @@ type Channel interface {
 	Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
 }
 
+// ConfirmChannel publishes and waits for the broker to confirm the message.
+type ConfirmChannel interface {
+	PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+}
+
 type Delivery interface {
 	Ack(multiple bool) error
 	Nack(multiple bool, requeue bool) error
@@ -40,7 +46,7 @@ func NewDeliveryAdapter(d amqp.Delivery) *DeliveryAdapter {
 }
 
 type EmailSender interface {
-	SendEmail(recipient string, body string, subject string) error
+	SendEmail(email Email) error
 }
 
 type InvalidEmailError struct {
@@ -52,33 +58,65 @@ func (e *InvalidEmailError) Error() string {
 	return fmt.Sprintf("%s - %s", e.Email, e.Message)
 }
 
+type MalformedMessageError struct {
+	Err error
+}
+
+func (e *MalformedMessageError) Error() string {
+	return fmt.Sprintf("malformed message: %s", e.Err)
+}
+
+func (e *MalformedMessageError) Unwrap() error {
+	return e.Err
+}
+
 type GmailSender struct {
+	Host     string
+	Port     string
+	From     string
+	Password string
+}
+
+func (g *GmailSender) SendEmail(email Email) error {
+	for _, list := range [][]string{email.To, email.Cc, email.Bcc} {
+		for _, recipient := range list {
+			if !consumer_util.Valid(recipient) {
+				return &InvalidEmailError{Email: recipient, Message: "Invalid email sending it to DLQ"}
+			}
+		}
+	}
+	to := email.Envelope()
+	if len(to) == 0 {
+		return &InvalidEmailError{Message: "No recipients sending it to DLQ"}
+	}
+
+	message, err := BuildMessage(g.From, email, time.Now())
+	if err != nil {
+		return err
+	}
+
+	auth := smtp.PlainAuth("", g.From, g.Password, g.Host)
+
+	err = smtp.SendMail(g.Host+":"+g.Port, auth, g.From, to, message)
+	return classifySMTPError(err)
+}
+
+// ThrottledSender paces Sender to the provider's quota. A send that would
+// have to wait longer than MaxWait fails with a *ratelimit.LimitedError, a
+// transient error, instead of holding the worker.
+type ThrottledSender struct {
+	Sender   EmailSender
+	Throttle *ratelimit.Throttle
+	MaxWait  time.Duration
 }
 
-func (g *GmailSender) SendEmail(recipient string, body string, subject string) error {
-	if !consumer_util.Valid(recipient) {
-		return &InvalidEmailError{Email: recipient, Message: "Invalid email sending it to DLQ"}
+func (t *ThrottledSender) SendEmail(email Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.MaxWait)
+	defer cancel()
+	if err := t.Throttle.Wait(ctx, len(email.Envelope())); err != nil {
+		return err
 	}
-	from := os.Getenv("FROM_EMAIL")
-	password := os.Getenv("APP_PASSWORD")
-	to := []string{recipient}
-
-	smtpHost := os.Getenv("SMTPHOST")
-	smtpPort := os.Getenv("SMTPPORT")
-
-	message := []byte(
-		"From: " + from + "\r\n" +
-			"To: " + recipient + "\r\n" +
-			"Subject: " + subject + "\r\n" +
-			"MIME-version: 1.0;\r\n" +
-			"Content-Type: text/plain; charset=\"UTF-8\";\r\n" +
-			"\r\n" +
-			body + "\r\n")
-
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, message)
-	return err
+	return t.Sender.SendEmail(email)
 }
 
 type DBExecutor interface {
@@ -0,0 +1,245 @@
+package consumer_types
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+)
+
+const maxHeaderLineLength = 78
+
+var (
+	tagPattern        = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]*>`)
+	blockTagPattern   = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|li|tr)>`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+type Email struct {
+	MessageId   string
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	ReplyTo     string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []types.Attachment
+}
+
+func BuildMessage(from string, e Email, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	if e.From != "" {
+		from = e.From
+	}
+	writeHeader(&buf, "From", formatAddressList([]string{from}))
+	writeHeader(&buf, "To", formatAddressList(e.To))
+	if len(e.Cc) > 0 {
+		writeHeader(&buf, "Cc", formatAddressList(e.Cc))
+	}
+	if e.ReplyTo != "" {
+		writeHeader(&buf, "Reply-To", formatAddressList([]string{e.ReplyTo}))
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	if e.MessageId != "" {
+		writeHeader(&buf, "Message-ID", "<"+e.MessageId+"@notify>")
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	text := e.Text
+	if text == "" && e.HTML != "" {
+		text = htmlToText(e.HTML)
+	}
+
+	if len(e.Attachments) == 0 {
+		contentType, body, err := buildBody(text, e.HTML)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(contentType)
+		buf.WriteString("\r\n")
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	writeHeader(&buf, "Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+	buf.WriteString("\r\n")
+	contentType, body, err := buildBody(text, e.HTML)
+	if err != nil {
+		return nil, err
+	}
+	part, err := mixed.CreatePart(parseHeaderBlock(contentType))
+	if err != nil {
+		return nil, err
+	}
+	part.Write(body)
+	for _, a := range e.Attachments {
+		if err := writeAttachment(mixed, a); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e Email) Envelope() []string {
+	var envelope []string
+	for _, list := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, address := range list {
+			if parsed, err := mail.ParseAddress(address); err == nil {
+				envelope = append(envelope, parsed.Address)
+			}
+		}
+	}
+	return envelope
+}
+
+func formatAddressList(addresses []string) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if parsed, err := mail.ParseAddress(address); err == nil && parsed.Name != "" {
+			formatted = append(formatted, parsed.String())
+		} else if err == nil {
+			formatted = append(formatted, parsed.Address)
+		} else {
+			formatted = append(formatted, address)
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func buildBody(text string, htmlBody string) (string, []byte, error) {
+	if htmlBody == "" {
+		body, err := quotedPrintable(text)
+		return textPartHeaders("text/plain"), body, err
+	}
+	var buf bytes.Buffer
+	alternative := multipart.NewWriter(&buf)
+	for _, p := range []struct{ mediaType, content string }{{"text/plain", text}, {"text/html", htmlBody}} {
+		part, err := alternative.CreatePart(parseHeaderBlock(textPartHeaders(p.mediaType)))
+		if err != nil {
+			return "", nil, err
+		}
+		body, err := quotedPrintable(p.content)
+		if err != nil {
+			return "", nil, err
+		}
+		part.Write(body)
+	}
+	if err := alternative.Close(); err != nil {
+		return "", nil, err
+	}
+	var headers bytes.Buffer
+	writeHeader(&headers, "Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+	return headers.String(), buf.Bytes(), nil
+}
+
+func textPartHeaders(mediaType string) string {
+	var headers bytes.Buffer
+	writeHeader(&headers, "Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"}))
+	writeHeader(&headers, "Content-Transfer-Encoding", "quoted-printable")
+	return headers.String()
+}
+
+func writeAttachment(w *multipart.Writer, a types.Attachment) error {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(a.Content)
+	for len(encoded) > 76 {
+		io.WriteString(part, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
+}
+
+func quotedPrintable(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parseHeaderBlock(block string) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	unfolded := strings.ReplaceAll(block, "\r\n ", " ")
+	for _, line := range strings.Split(unfolded, "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if ok {
+			header.Add(name, strings.TrimSpace(value))
+		}
+	}
+	return header
+}
+
+func writeHeader(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(foldHeader(name + ": " + sanitizeHeader(value)))
+	buf.WriteString("\r\n")
+}
+
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+func foldHeader(line string) string {
+	if len(line) <= maxHeaderLineLength {
+		return line
+	}
+	var out strings.Builder
+	current := 0
+	for i, word := range strings.Split(line, " ") {
+		if i > 0 {
+			if current+1+len(word) > maxHeaderLineLength {
+				out.WriteString("\r\n ")
+				current = 1
+			} else {
+				out.WriteString(" ")
+				current++
+			}
+		}
+		out.WriteString(word)
+		current += len(word)
+	}
+	return out.String()
+}
+
+func htmlToText(s string) string {
+	s = blockTagPattern.ReplaceAllString(s, "\n")
+	s = tagPattern.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -0,0 +1,171 @@
+package consumer_types
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDate = time.Date(2025, 6, 10, 9, 30, 0, 0, time.UTC)
+
+func readMessage(t *testing.T, raw []byte) *mail.Message {
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		assert.LessOrEqual(t, len(line), 998, "line exceeds RFC 5322 limit")
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	require.NoError(t, err)
+	return msg
+}
+
+func readParts(t *testing.T, r io.Reader, contentType string) ([]*multipart.Part, [][]byte) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(mediaType, "multipart/"))
+	reader := multipart.NewReader(r, params["boundary"])
+	var parts []*multipart.Part
+	var bodies [][]byte
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts = append(parts, part)
+		bodies = append(bodies, body)
+	}
+	return parts, bodies
+}
+
+func decodeQP(t *testing.T, b []byte) string {
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(b)))
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func TestBuildMessage_PlainText(t *testing.T) {
+	raw, err := BuildMessage("from@example.com", Email{MessageId: "msg-1", To: []string{"to@example.com"}, Subject: "Hello", Text: "Plain body"}, testDate)
+	require.NoError(t, err)
+
+	msg := readMessage(t, raw)
+	assert.Equal(t, "from@example.com", msg.Header.Get("From"))
+	assert.Equal(t, "to@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "Hello", msg.Header.Get("Subject"))
+	assert.Equal(t, "<msg-1@notify>", msg.Header.Get("Message-ID"))
+	assert.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+	assert.Equal(t, "text/plain; charset=utf-8", msg.Header.Get("Content-Type"))
+	assert.Equal(t, "quoted-printable", msg.Header.Get("Content-Transfer-Encoding"))
+	body, _ := io.ReadAll(msg.Body)
+	assert.Equal(t, "Plain body", decodeQP(t, body))
+}
+
+func TestBuildMessage_EncodesAndFoldsSubject(t *testing.T) {
+	subject := "Ihre Bestellbestätigung für Bestellung Nummer 12345 ist da – vielen Dank für Ihren Einkauf"
+	raw, err := BuildMessage("from@example.com", Email{To: []string{"to@example.com"}, Subject: subject, Text: "x"}, testDate)
+	require.NoError(t, err)
+
+	header, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	for _, line := range strings.Split(header, "\r\n") {
+		assert.LessOrEqual(t, len(line), maxHeaderLineLength)
+	}
+	msg := readMessage(t, raw)
+	decoded, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, subject, decoded)
+}
+
+func TestBuildMessage_StripsHeaderInjection(t *testing.T) {
+	raw, err := BuildMessage("from@example.com", Email{To: []string{"to@example.com"}, Subject: "Hi\r\nBcc: victim@example.com", Text: "x"}, testDate)
+	require.NoError(t, err)
+
+	msg := readMessage(t, raw)
+	assert.Empty(t, msg.Header.Get("Bcc"))
+}
+
+func TestBuildMessage_HTMLAlternative(t *testing.T) {
+	raw, err := BuildMessage("from@example.com", Email{To: []string{"to@example.com"}, Subject: "Receipt", HTML: "<h1>Thanks</h1><p>Total: &euro;12</p>"}, testDate)
+	require.NoError(t, err)
+
+	msg := readMessage(t, raw)
+	parts, bodies := readParts(t, msg.Body, msg.Header.Get("Content-Type"))
+	require.Len(t, parts, 2)
+	assert.Equal(t, "text/plain; charset=utf-8", parts[0].Header.Get("Content-Type"))
+	assert.Equal(t, "Thanks\r\nTotal: €12", decodeQP(t, bodies[0]))
+	assert.Equal(t, "text/html; charset=utf-8", parts[1].Header.Get("Content-Type"))
+	assert.Equal(t, "<h1>Thanks</h1><p>Total: &euro;12</p>", decodeQP(t, bodies[1]))
+}
+
+func TestBuildMessage_MixedWithAttachments(t *testing.T) {
+	pdf := bytes.Repeat([]byte("%PDF-1.4 binary\x00\xff"), 20)
+	raw, err := BuildMessage("from@example.com", Email{
+		To:      []string{"to@example.com"},
+		Subject: "Report",
+		Text:    "See attached",
+		HTML:    "<p>See attached</p>",
+		Attachments: []types.Attachment{
+			{Filename: "report.pdf", ContentType: "application/pdf", Content: pdf},
+			{Filename: "übersicht.csv", Content: []byte("a,b\n1,2\n")},
+		},
+	}, testDate)
+	require.NoError(t, err)
+
+	msg := readMessage(t, raw)
+	assert.True(t, strings.HasPrefix(msg.Header.Get("Content-Type"), "multipart/mixed;"))
+	parts, bodies := readParts(t, msg.Body, msg.Header.Get("Content-Type"))
+	require.Len(t, parts, 3)
+
+	inner, _ := readParts(t, bytes.NewReader(bodies[0]), parts[0].Header.Get("Content-Type"))
+	assert.Len(t, inner, 2)
+
+	assert.Equal(t, "application/pdf", parts[1].Header.Get("Content-Type"))
+	assert.Equal(t, "report.pdf", parts[1].FileName())
+	decoded, err := io.ReadAll(multipartBase64(bodies[1]))
+	require.NoError(t, err)
+	assert.Equal(t, pdf, decoded)
+
+	assert.Equal(t, "application/octet-stream", parts[2].Header.Get("Content-Type"))
+	assert.Equal(t, "übersicht.csv", parts[2].FileName())
+}
+
+func multipartBase64(b []byte) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.ReplaceAll(string(b), "\r\n", "")))
+}
+
+func TestBuildMessage_Recipients(t *testing.T) {
+	e := Email{
+		From:    "Billing <billing@example.com>",
+		To:      []string{"a@example.com", "Zoë <z@example.com>"},
+		Cc:      []string{"c@example.com"},
+		Bcc:     []string{"hidden@example.com"},
+		ReplyTo: "support@example.com",
+		Subject: "Invoice",
+		Text:    "x",
+	}
+	raw, err := BuildMessage("default@example.com", e, testDate)
+	require.NoError(t, err)
+
+	msg := readMessage(t, raw)
+	from, err := msg.Header.AddressList("From")
+	require.NoError(t, err)
+	assert.Equal(t, "billing@example.com", from[0].Address)
+	to, err := msg.Header.AddressList("To")
+	require.NoError(t, err)
+	require.Len(t, to, 2)
+	assert.Equal(t, "Zoë", to[1].Name)
+	assert.Equal(t, "c@example.com", msg.Header.Get("Cc"))
+	assert.Equal(t, "support@example.com", msg.Header.Get("Reply-To"))
+	assert.Empty(t, msg.Header.Get("Bcc"))
+	assert.Equal(t, []string{"a@example.com", "z@example.com", "c@example.com", "hidden@example.com"}, e.Envelope())
+}
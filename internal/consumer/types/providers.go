@@ -0,0 +1,301 @@
+package consumer_types
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+)
+
+type Provider interface {
+	Send(ctx context.Context, messageId string, n types.RequestBody) error
+}
+
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Provider)}
+}
+
+func (r *Registry) Register(channel string, p Provider) {
+	r.providers[channel] = p
+}
+
+func (r *Registry) Get(channel string) (Provider, error) {
+	p, ok := r.providers[channel]
+	if !ok {
+		return nil, &UnsupportedChannelError{Channel: channel}
+	}
+	return p, nil
+}
+
+type UnsupportedChannelError struct {
+	Channel string
+}
+
+func (e *UnsupportedChannelError) Error() string {
+	return fmt.Sprintf("no provider registered for channel %q", e.Channel)
+}
+
+type TemplateError struct {
+	TemplateId string
+	Err        error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("cannot render template %s: %s", e.TemplateId, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+type ProviderError struct {
+	Channel    string
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s provider responded with %d: %s", e.Channel, e.StatusCode, e.Message)
+}
+
+func (e *ProviderError) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+type EmailProvider struct {
+	Sender EmailSender
+}
+
+func (e *EmailProvider) Send(_ context.Context, messageId string, n types.RequestBody) error {
+	return e.Sender.SendEmail(Email{
+		MessageId:   messageId,
+		From:        n.From,
+		To:          n.Recipients(),
+		Cc:          n.Cc,
+		Bcc:         n.Bcc,
+		ReplyTo:     n.ReplyTo,
+		Subject:     n.Subject,
+		Text:        n.Message,
+		HTML:        n.HTML,
+		Attachments: n.Attachments,
+	})
+}
+
+type WebhookProvider struct {
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	MessageId string `json:"message_id"`
+	Subject   string `json:"subject,omitempty"`
+	Message   string `json:"message"`
+}
+
+func (w *WebhookProvider) Send(ctx context.Context, messageId string, n types.RequestBody) error {
+	payload := webhookPayload{MessageId: messageId, Subject: n.Subject, Message: n.Message}
+	return postJSON(ctx, w.Client, types.ChannelWebhook, n.URL, payload, map[string]string{"X-Notify-Message-Id": messageId})
+}
+
+type SlackProvider struct {
+	Client     *http.Client
+	WebhookURL string
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+func (s *SlackProvider) Send(ctx context.Context, _ string, n types.RequestBody) error {
+	target := n.URL
+	if target == "" {
+		target = s.WebhookURL
+	}
+	if target == "" {
+		return &ProviderError{Channel: types.ChannelSlack, StatusCode: http.StatusBadRequest, Message: "no webhook URL configured"}
+	}
+	text := n.Message
+	if n.Subject != "" {
+		text = "*" + n.Subject + "*\n" + n.Message
+	}
+	return postJSON(ctx, s.Client, types.ChannelSlack, target, slackPayload{Text: text}, nil)
+}
+
+type SMSProvider struct {
+	Client     *http.Client
+	GatewayURL string
+	Token      string
+	From       string
+}
+
+type smsPayload struct {
+	To      string `json:"to"`
+	From    string `json:"from,omitempty"`
+	Message string `json:"message"`
+}
+
+func (s *SMSProvider) Send(ctx context.Context, messageId string, n types.RequestBody) error {
+	headers := map[string]string{"X-Notify-Message-Id": messageId}
+	if s.Token != "" {
+		headers["Authorization"] = "Bearer " + s.Token
+	}
+	payload := smsPayload{To: n.Phone, From: s.From, Message: n.Message}
+	return postJSON(ctx, s.Client, types.ChannelSMS, s.GatewayURL, payload, headers)
+}
+
+var ErrBlockedAddress = errors.New("destination address is not allowed")
+
+func NewHTTPClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// NewPublicHTTPClient returns a client that only connects to public
+// addresses. Webhook and Slack URLs come from the request body, so the check
+// runs in the dialer, after DNS resolution and on every redirect, rather than
+// on the URL.
+func NewPublicHTTPClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, channel string, target string, payload any, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return &ProviderError{Channel: channel, StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if errors.Is(err, ErrBlockedAddress) {
+		return &ProviderError{Channel: channel, StatusCode: http.StatusForbidden, Message: ErrBlockedAddress.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &ProviderError{
+			Channel:    channel,
+			StatusCode: resp.StatusCode,
+			Message:    string(bytes.TrimSpace(msg)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	return nil
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date. It returns 0 when the header is missing or malformed.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+func IsPermanent(err error) bool {
+	switch e := err.(type) {
+	case *InvalidEmailError, *UnsupportedChannelError, *TemplateError, *PermanentSMTPError, *MalformedMessageError:
+		return true
+	case *ProviderError:
+		return e.Permanent()
+	default:
+		return false
+	}
+}
+
+const (
+	ErrorClassPermanent   = "permanent"
+	ErrorClassTransient   = "transient"
+	ErrorClassRateLimited = "rate_limited"
+)
+
+// ErrorClass labels err as permanent, transient or rate limited. Only
+// permanent errors skip the retry queues.
+func ErrorClass(err error) string {
+	var smtpLimited *RateLimitedSMTPError
+	var throttled *ratelimit.LimitedError
+	var providerErr *ProviderError
+	switch {
+	case errors.As(err, &smtpLimited), errors.As(err, &throttled):
+		return ErrorClassRateLimited
+	case errors.As(err, &providerErr) && providerErr.StatusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case IsPermanent(err):
+		return ErrorClassPermanent
+	default:
+		return ErrorClassTransient
+	}
+}
+
+// RetryAfter returns how long a rate limited err asked us to wait, or 0 when
+// it did not say.
+func RetryAfter(err error) time.Duration {
+	var throttled *ratelimit.LimitedError
+	var providerErr *ProviderError
+	switch {
+	case errors.As(err, &throttled):
+		return throttled.RetryAfter
+	case errors.As(err, &providerErr):
+		return providerErr.RetryAfter
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,165 @@
+package consumer_types
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type capturedRequest struct {
+	Header http.Header
+	Body   map[string]string
+}
+
+func newStandIn(t *testing.T, status int) (*httptest.Server, *capturedRequest) {
+	captured := &capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured.Header = r.Header.Clone()
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&captured.Body))
+		w.WriteHeader(status)
+		w.Write([]byte("stand-in response"))
+	}))
+	t.Cleanup(server.Close)
+	return server, captured
+}
+
+func TestWebhookProvider_Send(t *testing.T) {
+	server, captured := newStandIn(t, http.StatusAccepted)
+	provider := &WebhookProvider{Client: server.Client()}
+
+	err := provider.Send(context.Background(), "msg-1", types.RequestBody{Channel: types.ChannelWebhook, URL: server.URL, Subject: "deploy", Message: "done"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "msg-1", captured.Header.Get("X-Notify-Message-Id"))
+	assert.Equal(t, map[string]string{"message_id": "msg-1", "subject": "deploy", "message": "done"}, captured.Body)
+}
+
+func TestSlackProvider_Send(t *testing.T) {
+	server, captured := newStandIn(t, http.StatusOK)
+	provider := &SlackProvider{Client: server.Client(), WebhookURL: server.URL}
+
+	err := provider.Send(context.Background(), "msg-1", types.RequestBody{Channel: types.ChannelSlack, Subject: "Alert", Message: "disk full"})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"text": "*Alert*\ndisk full"}, captured.Body)
+}
+
+func TestSlackProvider_NoURLIsPermanent(t *testing.T) {
+	provider := &SlackProvider{}
+
+	err := provider.Send(context.Background(), "msg-1", types.RequestBody{Channel: types.ChannelSlack, Message: "disk full"})
+
+	assert.True(t, IsPermanent(err))
+}
+
+func TestSMSProvider_Send(t *testing.T) {
+	server, captured := newStandIn(t, http.StatusOK)
+	provider := &SMSProvider{Client: server.Client(), GatewayURL: server.URL, Token: "secret", From: "Notify"}
+
+	err := provider.Send(context.Background(), "msg-1", types.RequestBody{Channel: types.ChannelSMS, Phone: "+15551234567", Message: "code 1234"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer secret", captured.Header.Get("Authorization"))
+	assert.Equal(t, map[string]string{"to": "+15551234567", "from": "Notify", "message": "code 1234"}, captured.Body)
+}
+
+func TestWebhookProvider_BlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback address")
+	}))
+	t.Cleanup(server.Close)
+	provider := &WebhookProvider{Client: NewPublicHTTPClient()}
+
+	for _, target := range []string{server.URL, "http://10.0.0.1/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]:8080/"} {
+		err := provider.Send(context.Background(), "msg-1", types.RequestBody{URL: target, Message: "m"})
+
+		var providerErr *ProviderError
+		require.ErrorAs(t, err, &providerErr, target)
+		assert.Equal(t, http.StatusForbidden, providerErr.StatusCode)
+		assert.True(t, IsPermanent(err), target)
+	}
+}
+
+func TestProviderError_Classification(t *testing.T) {
+	cases := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusRequestTimeout, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+	}
+	for _, tc := range cases {
+		server, _ := newStandIn(t, tc.status)
+		provider := &WebhookProvider{Client: server.Client()}
+
+		err := provider.Send(context.Background(), "msg-1", types.RequestBody{URL: server.URL, Message: "m"})
+
+		var providerErr *ProviderError
+		require.ErrorAs(t, err, &providerErr)
+		assert.Equal(t, tc.status, providerErr.StatusCode)
+		assert.Equal(t, "stand-in response", providerErr.Message)
+		assert.Equal(t, tc.permanent, IsPermanent(err), "status %d", tc.status)
+	}
+}
+
+func TestProviderError_RetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	provider := &WebhookProvider{Client: server.Client()}
+
+	err := provider.Send(context.Background(), "msg-1", types.RequestBody{URL: server.URL, Message: "m"})
+
+	assert.Equal(t, ErrorClassRateLimited, ErrorClass(err))
+	assert.Equal(t, 2*time.Minute, RetryAfter(err))
+}
+
+func TestRegistry_Get(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(types.ChannelWebhook, &WebhookProvider{})
+
+	p, err := registry.Get(types.ChannelWebhook)
+	assert.NoError(t, err)
+	assert.NotNil(t, p)
+
+	_, err = registry.Get(types.ChannelSMS)
+	var unsupported *UnsupportedChannelError
+	assert.ErrorAs(t, err, &unsupported)
+	assert.True(t, IsPermanent(err))
+}
+
+type countingSender struct {
+	sent int
+}
+
+func (c *countingSender) SendEmail(Email) error {
+	c.sent++
+	return nil
+}
+
+func TestThrottledSender_FailsTransientlyOverQuota(t *testing.T) {
+	inner := &countingSender{}
+	sender := &ThrottledSender{Sender: inner, Throttle: ratelimit.NewThrottle(0, 2), MaxWait: 10 * time.Millisecond}
+
+	require.NoError(t, sender.SendEmail(Email{To: []string{"a@b.com"}, Cc: []string{"c@d.com"}}))
+	err := sender.SendEmail(Email{To: []string{"a@b.com"}})
+
+	var limited *ratelimit.LimitedError
+	assert.ErrorAs(t, err, &limited)
+	assert.False(t, IsPermanent(err), "over quota must be retried, not dead-lettered")
+	assert.Equal(t, 1, inner.sent)
+}
@@ -0,0 +1,51 @@
+package consumer_types
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+type SMTPError struct {
+	Code    int
+	Message string
+}
+
+func (e *SMTPError) Error() string {
+	return fmt.Sprintf("smtp server replied %d: %s", e.Code, e.Message)
+}
+
+// PermanentSMTPError is a 5xx reply about the message or its recipients, such
+// as 550 mailbox unavailable. Sending it again will fail the same way.
+type PermanentSMTPError struct{ SMTPError }
+
+type TransientSMTPError struct{ SMTPError }
+
+// RateLimitedSMTPError means the server is throttling our account, either
+// for the moment (4.7.x) or for the day (5.4.5).
+type RateLimitedSMTPError struct{ SMTPError }
+
+// classifySMTPError maps a *textproto.Error returned by net/smtp to one of the
+// SMTP error types. Other errors, e.g. a dropped connection, are returned
+// unchanged and treated as transient.
+func classifySMTPError(err error) error {
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return err
+	}
+	base := SMTPError{Code: reply.Code, Message: reply.Msg}
+	enhanced, _, _ := strings.Cut(reply.Msg, " ")
+	switch {
+	case reply.Code == 421 || strings.HasPrefix(enhanced, "4.7.") || enhanced == "5.4.5":
+		return &RateLimitedSMTPError{base}
+	case reply.Code == 530 || reply.Code == 534 || reply.Code == 535:
+		// Authentication failures are a problem with our credentials, not with
+		// the message, so keep retrying while someone fixes the configuration.
+		return &TransientSMTPError{base}
+	case reply.Code >= 500:
+		return &PermanentSMTPError{base}
+	default:
+		return &TransientSMTPError{base}
+	}
+}
@@ -0,0 +1,55 @@
+package consumer_types
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifySMTPError(t *testing.T) {
+	cases := []struct {
+		code  int
+		msg   string
+		class string
+	}{
+		{550, "5.1.1 The email account that you tried to reach does not exist", ErrorClassPermanent},
+		{553, "5.1.3 Invalid address", ErrorClassPermanent},
+		{554, "5.7.1 Message rejected", ErrorClassPermanent},
+		{550, "5.4.5 Daily user sending quota exceeded", ErrorClassRateLimited},
+		{421, "4.7.0 Try again later, closing connection", ErrorClassRateLimited},
+		{450, "4.7.1 Too many messages", ErrorClassRateLimited},
+		{451, "4.3.0 Mail server temporarily rejected message", ErrorClassTransient},
+		{452, "4.2.2 Mailbox full", ErrorClassTransient},
+		{535, "5.7.8 Username and Password not accepted", ErrorClassTransient},
+	}
+	for _, tc := range cases {
+		err := classifySMTPError(&textproto.Error{Code: tc.code, Msg: tc.msg})
+
+		assert.Equal(t, tc.class, ErrorClass(err), "%d %s", tc.code, tc.msg)
+		assert.Equal(t, tc.class == ErrorClassPermanent, IsPermanent(err), "%d %s", tc.code, tc.msg)
+		assert.Contains(t, err.Error(), tc.msg)
+	}
+}
+
+func TestClassifySMTPError_NonReplyErrors(t *testing.T) {
+	assert.NoError(t, classifySMTPError(nil))
+
+	dropped := errors.New("connection reset by peer")
+	assert.Equal(t, dropped, classifySMTPError(dropped))
+	assert.Equal(t, ErrorClassTransient, ErrorClass(dropped))
+
+	wrapped := classifySMTPError(fmt.Errorf("sending: %w", &textproto.Error{Code: 550, Msg: "5.1.1 unknown user"}))
+	assert.True(t, IsPermanent(wrapped))
+}
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, ErrorClassRateLimited, ErrorClass(&ratelimit.LimitedError{}))
+	assert.Equal(t, ErrorClassRateLimited, ErrorClass(&ProviderError{StatusCode: 429}))
+	assert.Equal(t, ErrorClassPermanent, ErrorClass(&ProviderError{StatusCode: 404}))
+	assert.Equal(t, ErrorClassTransient, ErrorClass(&ProviderError{StatusCode: 503}))
+	assert.Equal(t, ErrorClassPermanent, ErrorClass(&InvalidEmailError{}))
+}
@@ -1,9 +1,34 @@
 package consumer_util
 
-import "net/mail"
+import (
+	"fmt"
+	"net/mail"
+)
+
+type AddressError struct {
+	Field   string
+	Address string
+	Reason  string
+}
+
+func (e AddressError) String() string {
+	return fmt.Sprintf("%s: %q %s", e.Field, e.Address, e.Reason)
+}
 
 func Valid(email string) bool {
 	_, err := mail.ParseAddress(email)
 	return err == nil
 }
 
+func ValidateAddresses(field string, addresses []string) ([]string, []AddressError) {
+	var valid []string
+	var invalid []AddressError
+	for i, address := range addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			invalid = append(invalid, AddressError{Field: fmt.Sprintf("%s[%d]", field, i), Address: address, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, address)
+	}
+	return valid, invalid
+}
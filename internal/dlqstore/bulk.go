@@ -0,0 +1,266 @@
+package dlqstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	common "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	"github.com/jayanth-parthsarathy/notify/internal/dlqstore/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobCancelled = "cancelled"
+)
+
+const (
+	ResultRequeued     = "requeued"
+	ResultWouldRequeue = "would_requeue"
+	ResultNotFound     = "not_found"
+	ResultSkipped      = "skipped"
+	ResultFailed       = "failed"
+)
+
+const (
+	defaultBulkRate = 20
+	maxBulkRate     = 500
+	maxBulkMessages = 10000
+	// Finished jobs are kept this long for progress queries.
+	jobRetention = 24 * time.Hour
+)
+
+type BulkResult struct {
+	MessageID string    `json:"message_id"`
+	Status    string    `json:"status"`
+	State     string    `json:"state,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+type BulkJob struct {
+	ID         string       `json:"id"`
+	State      string       `json:"state"`
+	DryRun     bool         `json:"dry_run"`
+	Total      int          `json:"total"`
+	Processed  int          `json:"processed"`
+	Requeued   int          `json:"requeued"`
+	Skipped    int          `json:"skipped"`
+	Failed     int          `json:"failed"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Results    []BulkResult `json:"results"`
+}
+
+// BulkRequeuer runs bulk requeues as background jobs. Jobs live in memory, so
+// progress is lost when the process restarts.
+type BulkRequeuer struct {
+	Inspector dlqstore_types.DLQInspector
+
+	mu   sync.Mutex
+	jobs map[string]*BulkJob
+}
+
+func NewBulkRequeuer(inspector dlqstore_types.DLQInspector) *BulkRequeuer {
+	return &BulkRequeuer{Inspector: inspector, jobs: make(map[string]*BulkJob)}
+}
+
+// Start resolves the messages req selects and requeues them in the
+// background until done or ctx is cancelled. Selection errors, including
+// ErrInvalidFilter, are returned before a job is created.
+func (b *BulkRequeuer) Start(ctx context.Context, req dlqstore_types.BulkRequest) (BulkJob, error) {
+	rate := req.RatePerSecond
+	switch {
+	case rate == 0:
+		rate = defaultBulkRate
+	case rate < 0 || rate > maxBulkRate:
+		return BulkJob{}, invalidFilter("rate_per_second must be between 1 and %d", maxBulkRate)
+	}
+	ids, unresolved, err := b.resolve(req)
+	if err != nil {
+		return BulkJob{}, err
+	}
+
+	job := &BulkJob{ID: uuid.New().String(), State: JobRunning, DryRun: req.DryRun, Total: len(ids) + len(unresolved), StartedAt: time.Now().UTC()}
+	for _, result := range unresolved {
+		result.At = job.StartedAt
+		job.Results = append(job.Results, result)
+		job.Processed++
+		if result.Status == ResultSkipped {
+			job.Skipped++
+		} else {
+			job.Failed++
+		}
+	}
+	b.mu.Lock()
+	b.prune(job.StartedAt)
+	b.jobs[job.ID] = job
+	snapshot := job.snapshot()
+	b.mu.Unlock()
+
+	logrus.Infof("Bulk requeue %s: %d messages (dry run: %t)", job.ID, job.Total, job.DryRun)
+	go b.run(ctx, job, ids, req.RequestedBy, ratelimit.NewThrottle(rate, 0))
+	return snapshot, nil
+}
+
+func (b *BulkRequeuer) Get(id string) (BulkJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if !ok {
+		return BulkJob{}, false
+	}
+	return job.snapshot(), true
+}
+
+// resolve lists the IDs of the selected messages. In ID mode it also returns
+// a result for each requested ID that has no stored entry: skipped, with its
+// current state, when the message is being or was already requeued, and
+// not_found when it is not in the DLQ at all.
+func (b *BulkRequeuer) resolve(req dlqstore_types.BulkRequest) ([]string, []BulkResult, error) {
+	var filter dlqstore_types.Filter
+	switch {
+	case len(req.MessageIDs) > 0 && req.Filter != nil:
+		return nil, nil, invalidFilter("give either message_ids or filter, not both")
+	case len(req.MessageIDs) > maxBulkMessages:
+		return nil, nil, invalidFilter("at most %d message_ids are allowed", maxBulkMessages)
+	case len(req.MessageIDs) > 0:
+		filter.MessageIDs = req.MessageIDs
+	case req.Filter != nil:
+		filter = *req.Filter
+	default:
+		return nil, nil, invalidFilter("message_ids or filter is required")
+	}
+	filter.Limit, filter.Cursor = maxPageSize, ""
+	filter.Status = dlqstore_types.StatusStored
+
+	var ids []string
+	seen := make(map[string]bool)
+	for {
+		page, err := b.Inspector.ListMessages(filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		if page.Total > maxBulkMessages {
+			return nil, nil, invalidFilter("filter matches %d messages, more than the %d allowed", page.Total, maxBulkMessages)
+		}
+		for _, msg := range page.Messages {
+			if !seen[msg.ID] {
+				seen[msg.ID] = true
+				ids = append(ids, msg.ID)
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+
+	var missing []string
+	for _, id := range req.MessageIDs {
+		if !seen[id] && !slices.Contains(missing, id) {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return ids, nil, nil
+	}
+	states, err := b.states(missing)
+	if err != nil {
+		return nil, nil, err
+	}
+	unresolved := make([]BulkResult, len(missing))
+	for i, id := range missing {
+		unresolved[i] = BulkResult{MessageID: id, Status: ResultNotFound}
+		if state, ok := states[id]; ok {
+			unresolved[i].Status, unresolved[i].State = ResultSkipped, state
+		}
+	}
+	return ids, unresolved, nil
+}
+
+// states returns the status of the newest entry for each of ids in the DLQ.
+func (b *BulkRequeuer) states(ids []string) (map[string]string, error) {
+	filter := dlqstore_types.Filter{MessageIDs: ids, Limit: maxPageSize}
+	states := make(map[string]string)
+	for {
+		page, err := b.Inspector.ListMessages(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range page.Messages {
+			if _, ok := states[msg.ID]; !ok {
+				states[msg.ID] = msg.Status
+			}
+		}
+		if page.NextCursor == "" {
+			return states, nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+func (b *BulkRequeuer) run(ctx context.Context, job *BulkJob, ids []string, requestedBy string, throttle *ratelimit.Throttle) {
+	state := JobCompleted
+	for _, id := range ids {
+		if err := throttle.Wait(ctx, 1); err != nil || ctx.Err() != nil {
+			state = JobCancelled
+			break
+		}
+		result := BulkResult{MessageID: id, Status: ResultWouldRequeue}
+		if !job.DryRun {
+			result.Status = ResultRequeued
+			if err := b.Inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: id, RequestedBy: requestedBy}); err != nil {
+				common.LogError(err, fmt.Sprintf("Bulk requeue %s: failed to requeue %s", job.ID, id))
+				result.Status, result.Error = ResultFailed, err.Error()
+			}
+		}
+		result.At = time.Now().UTC()
+		logrus.Infof("Bulk requeue %s: %s %s", job.ID, id, result.Status)
+		b.record(job, result)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	finished := time.Now().UTC()
+	job.State, job.FinishedAt = state, &finished
+	logrus.Infof("Bulk requeue %s %s: %d/%d processed, %d requeued, %d failed", job.ID, state, job.Processed, job.Total, job.Requeued, job.Failed)
+}
+
+func (b *BulkRequeuer) record(job *BulkJob, result BulkResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job.Results = append(job.Results, result)
+	job.Processed++
+	switch result.Status {
+	case ResultRequeued:
+		job.Requeued++
+	case ResultFailed:
+		job.Failed++
+	}
+}
+
+// prune drops finished jobs past jobRetention. Callers hold b.mu.
+func (b *BulkRequeuer) prune(now time.Time) {
+	for id, job := range b.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > jobRetention {
+			delete(b.jobs, id)
+		}
+	}
+}
+
+// snapshot copies job for use outside b.mu.
+func (job *BulkJob) snapshot() BulkJob {
+	c := *job
+	c.Results = slices.Clone(job.Results)
+	if c.Results == nil {
+		c.Results = []BulkResult{}
+	}
+	return c
+}
@@ -0,0 +1,223 @@
+package dlqstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dlqstore_types "github.com/jayanth-parthsarathy/notify/internal/dlqstore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memInspector pages through ids the way PostgresInspector does, with the
+// cursor holding the offset of the next page. IDs missing from states are
+// stored.
+type memInspector struct {
+	ids     []string
+	states  map[string]string
+	failing map[string]bool
+
+	mu       sync.Mutex
+	requeued []string
+}
+
+func (m *memInspector) ListMessages(filter dlqstore_types.Filter) (dlqstore_types.Page, error) {
+	var matching []string
+	for _, id := range m.ids {
+		if len(filter.MessageIDs) > 0 && !slices.Contains(filter.MessageIDs, id) {
+			continue
+		}
+		if filter.Status == "" || filter.Status == m.state(id) {
+			matching = append(matching, id)
+		}
+	}
+	start, _ := strconv.Atoi(filter.Cursor)
+	end := min(start+filter.Limit, len(matching))
+	page := dlqstore_types.Page{Total: len(matching)}
+	for _, id := range matching[start:end] {
+		page.Messages = append(page.Messages, dlqstore_types.DeadLetterMessage{ID: id, Status: m.state(id)})
+	}
+	if end < len(matching) {
+		page.NextCursor = strconv.Itoa(end)
+	}
+	return page, nil
+}
+
+func (m *memInspector) state(id string) string {
+	if state, ok := m.states[id]; ok {
+		return state
+	}
+	return dlqstore_types.StatusStored
+}
+
+func (m *memInspector) RequeueMessage(req dlqstore_types.RequestBody) error {
+	id := req.MessageId
+	if m.failing[id] {
+		return errors.New("broker unavailable")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requeued = append(m.requeued, id)
+	return nil
+}
+
+func waitForJob(t *testing.T, bulk *BulkRequeuer, id string) BulkJob {
+	var job BulkJob
+	require.Eventually(t, func() bool {
+		job, _ = bulk.Get(id)
+		return job.State != JobRunning
+	}, 5*time.Second, 5*time.Millisecond)
+	return job
+}
+
+func TestBulkRequeuer_Filter(t *testing.T) {
+	inspector := &memInspector{}
+	for i := range 150 {
+		inspector.ids = append(inspector.ids, fmt.Sprintf("msg-%d", i))
+	}
+	bulk := NewBulkRequeuer(inspector)
+
+	started, err := bulk.Start(context.Background(), dlqstore_types.BulkRequest{
+		Filter:        &dlqstore_types.Filter{Recipient: "@example.com"},
+		RatePerSecond: maxBulkRate,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 150, started.Total)
+
+	job := waitForJob(t, bulk, started.ID)
+	assert.Equal(t, JobCompleted, job.State)
+	assert.Equal(t, 150, job.Processed)
+	assert.Equal(t, 150, job.Requeued)
+	assert.NotNil(t, job.FinishedAt)
+	assert.Equal(t, inspector.ids, inspector.requeued)
+}
+
+func TestBulkRequeuer_IDs(t *testing.T) {
+	inspector := &memInspector{ids: []string{"a", "b", "c"}, failing: map[string]bool{"b": true}}
+	bulk := NewBulkRequeuer(inspector)
+
+	started, err := bulk.Start(context.Background(), dlqstore_types.BulkRequest{MessageIDs: []string{"c", "b", "a", "gone"}})
+	require.NoError(t, err)
+
+	job := waitForJob(t, bulk, started.ID)
+	assert.Equal(t, 4, job.Total)
+	assert.Equal(t, 4, job.Processed)
+	assert.Equal(t, 2, job.Requeued)
+	assert.Equal(t, 2, job.Failed)
+	statuses := map[string]string{}
+	for _, r := range job.Results {
+		statuses[r.MessageID] = r.Status
+	}
+	assert.Equal(t, map[string]string{"a": ResultRequeued, "b": ResultFailed, "c": ResultRequeued, "gone": ResultNotFound}, statuses)
+	assert.ElementsMatch(t, []string{"a", "c"}, inspector.requeued)
+}
+
+func TestBulkRequeuer_IDsNotStored(t *testing.T) {
+	inspector := &memInspector{
+		ids:    []string{"a", "b", "c"},
+		states: map[string]string{"b": dlqstore_types.StatusRequeuing, "c": dlqstore_types.StatusRequeued},
+	}
+	bulk := NewBulkRequeuer(inspector)
+
+	started, err := bulk.Start(context.Background(), dlqstore_types.BulkRequest{MessageIDs: []string{"a", "b", "c", "gone"}})
+	require.NoError(t, err)
+
+	job := waitForJob(t, bulk, started.ID)
+	assert.Equal(t, 4, job.Processed)
+	assert.Equal(t, 1, job.Requeued)
+	assert.Equal(t, 2, job.Skipped)
+	assert.Equal(t, 1, job.Failed)
+	results := map[string]BulkResult{}
+	for _, r := range job.Results {
+		results[r.MessageID] = r
+	}
+	assert.Equal(t, ResultRequeued, results["a"].Status)
+	assert.Equal(t, ResultSkipped, results["b"].Status)
+	assert.Equal(t, dlqstore_types.StatusRequeuing, results["b"].State)
+	assert.Equal(t, ResultSkipped, results["c"].Status)
+	assert.Equal(t, dlqstore_types.StatusRequeued, results["c"].State)
+	assert.Equal(t, ResultNotFound, results["gone"].Status)
+	assert.Equal(t, []string{"a"}, inspector.requeued)
+}
+
+func TestBulkRequeuer_DryRun(t *testing.T) {
+	inspector := &memInspector{ids: []string{"a", "b"}}
+	bulk := NewBulkRequeuer(inspector)
+
+	started, err := bulk.Start(context.Background(), dlqstore_types.BulkRequest{Filter: &dlqstore_types.Filter{}, DryRun: true})
+	require.NoError(t, err)
+
+	job := waitForJob(t, bulk, started.ID)
+	assert.True(t, job.DryRun)
+	assert.Equal(t, 2, job.Processed)
+	assert.Equal(t, 0, job.Requeued)
+	for _, r := range job.Results {
+		assert.Equal(t, ResultWouldRequeue, r.Status)
+	}
+	assert.Empty(t, inspector.requeued)
+}
+
+func TestBulkRequeuer_Cancelled(t *testing.T) {
+	inspector := &memInspector{ids: []string{"a", "b", "c"}}
+	bulk := NewBulkRequeuer(inspector)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started, err := bulk.Start(ctx, dlqstore_types.BulkRequest{Filter: &dlqstore_types.Filter{}, RatePerSecond: 1})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, _ := bulk.Get(started.ID)
+		return job.Processed == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	job := waitForJob(t, bulk, started.ID)
+	assert.Equal(t, JobCancelled, job.State)
+	assert.Equal(t, 1, job.Processed)
+}
+
+func TestBulkRequeuer_InvalidRequests(t *testing.T) {
+	bulk := NewBulkRequeuer(&memInspector{})
+	for _, req := range []dlqstore_types.BulkRequest{
+		{},
+		{MessageIDs: []string{"a"}, Filter: &dlqstore_types.Filter{}},
+		{MessageIDs: []string{"a"}, RatePerSecond: maxBulkRate + 1},
+		{MessageIDs: make([]string, maxBulkMessages+1)},
+	} {
+		_, err := bulk.Start(context.Background(), req)
+		assert.ErrorIs(t, err, ErrInvalidFilter)
+	}
+}
+
+func TestHandleBulkRequeue(t *testing.T) {
+	bulk := NewBulkRequeuer(&memInspector{ids: []string{"a"}})
+
+	rec := httptest.NewRecorder()
+	handleBulkRequeue(context.Background(), rec, httptest.NewRequest(http.MethodPost, "/requeue/bulk", strings.NewReader(`{"message_ids":["a"],"dry_run":true}`)), bulk)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	location := rec.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(location, "/requeue/bulk/"))
+
+	rec = httptest.NewRecorder()
+	handleBulkRequeue(context.Background(), rec, httptest.NewRequest(http.MethodPost, "/requeue/bulk", strings.NewReader(`{}`)), bulk)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /requeue/bulk/{id}", func(w http.ResponseWriter, req *http.Request) { handleBulkStatus(w, req, bulk) })
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, location, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"dry_run":true`)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/requeue/bulk/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
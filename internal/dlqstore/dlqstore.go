@@ -2,12 +2,21 @@ package dlqstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 
 	"encoding/json"
 	"net/http"
-
-	"github.com/jackc/pgx/v4"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
+	"github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	"github.com/jayanth-parthsarathy/notify/internal/common/health"
+	"github.com/jayanth-parthsarathy/notify/internal/common/metrics"
+	"github.com/jayanth-parthsarathy/notify/internal/common/retry"
+	"github.com/jayanth-parthsarathy/notify/internal/common/util"
 	"github.com/jayanth-parthsarathy/notify/internal/dlqstore/types"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
@@ -18,109 +27,141 @@ import (
 type PostgresInspector struct {
 	Conn        dlqstore_types.AMQPConnection
 	RequeueName string
-	DB          dlqstore_types.DB
+	// RetryQueues are the retry queues a requeue may target instead of
+	// RequeueName, directly or through the retry exchange.
+	RetryQueues    []string
+	DB             dlqstore_types.DB
+	ConfirmTimeout time.Duration
 }
 
-func (p *PostgresInspector) ListMessages(limit int) ([]dlqstore_types.DeadLetterMessage, error) {
-	rows, err := p.DB.Query(context.Background(), `SELECT message_id, headers, body FROM dlq_messages ORDER BY received_at DESC LIMIT $1`, limit)
+// ListMessages returns the newest entries matching filter, one page at a time.
+func (p *PostgresInspector) ListMessages(filter dlqstore_types.Filter) (dlqstore_types.Page, error) {
+	var page dlqstore_types.Page
+	if err := validateFilter(&filter); err != nil {
+		return page, err
+	}
+	ctx := context.Background()
+	where, args := whereClause(filter)
+	err := p.DB.QueryRow(ctx, `SELECT count(*) FROM dlq_messages`+where, args...).Scan(&page.Total)
+	if err != nil {
+		return page, queryError(err)
+	}
+
+	after, _ := decodeCursor(filter.Cursor)
+	if after > 0 {
+		args = append(args, after)
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += "id < $" + strconv.Itoa(len(args))
+	}
+	args = append(args, filter.Limit+1)
+	rows, err := p.DB.Query(ctx,
+		`SELECT id, message_id, headers, body, reason, error_class, attempts, first_seen_at, original_queue, status, requeued_at FROM dlq_messages`+
+			where+` ORDER BY id DESC LIMIT $`+strconv.Itoa(len(args)),
+		args...,
+	)
 	if err != nil {
-		return nil, err
+		return page, queryError(err)
 	}
 	defer rows.Close()
 
-	var messages []dlqstore_types.DeadLetterMessage
+	page.Messages = []dlqstore_types.DeadLetterMessage{}
+	var lastID int64
 	for rows.Next() {
+		if len(page.Messages) == filter.Limit {
+			page.NextCursor = encodeCursor(lastID)
+			break
+		}
 		var msg dlqstore_types.DeadLetterMessage
 		var headers, body []byte
-		err := rows.Scan(&msg.ID, &headers, &body)
+		err := rows.Scan(&lastID, &msg.ID, &headers, &body, &msg.Reason, &msg.Type, &msg.Attempts, &msg.FirstSeen, &msg.OriginalQueue, &msg.Status, &msg.RequeuedAt)
 		if err != nil {
-			return nil, err
+			return page, err
 		}
 		var headersMap amqp.Table
 		if err := json.Unmarshal(headers, &headersMap); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal headers: %w", err)
+			return page, fmt.Errorf("failed to unmarshal headers: %w", err)
 		}
 		msg.Headers = headersMap
 		msg.Payload = string(body)
-		messages = append(messages, msg)
+		page.Messages = append(page.Messages, msg)
 	}
-	return messages, nil
+	return page, queryError(rows.Err())
 }
 
-func (p *PostgresInspector) RequeueMessage(messageId string) error {
-	ch, err := p.Conn.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
-
-	var headersJSON, body []byte
-	err = p.DB.QueryRow(context.Background(),
-		`SELECT headers, body FROM dlq_messages WHERE message_id = $1`, messageId,
-	).Scan(&headersJSON, &body)
-	if err != nil {
-		return fmt.Errorf("failed to fetch message from db: %w", err)
+func NewPgInspector(db dlqstore_types.DB, conn dlqstore_types.AMQPConnection, requeueName string, policy retry.Policy) *PostgresInspector {
+	var retryQueues []string
+	for _, q := range policy.Queues() {
+		retryQueues = append(retryQueues, q.Name)
 	}
-
-	var headers amqp.Table
-	if err := json.Unmarshal(headersJSON, &headers); err != nil {
-		return fmt.Errorf("failed to unmarshal headers: %w", err)
-	}
-
-	clean := make(amqp.Table, len(headers))
-	for k, v := range headers {
-		if k == "x-death" {
-			continue
-		}
-		clean[k] = v
-	}
-
-	pub := amqp.Publishing{
-		ContentType:  "application/json",
-		Body:         body,
-		Headers:      clean,
-		DeliveryMode: amqp.Persistent,
-		MessageId:    messageId,
-	}
-
-	if err := ch.Publish("", p.RequeueName, false, false, pub); err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
-	}
-	_, err = p.DB.Exec(context.Background(), `DELETE FROM dlq_messages WHERE message_id = $1`, messageId)
-	if err != nil {
-		return fmt.Errorf("failed to delete message after requeue: %w", err)
-	}
-
-	logrus.Infof("Message %s requeued successfully", messageId)
-	return nil
+	return &PostgresInspector{DB: db, Conn: conn, RequeueName: requeueName, RetryQueues: retryQueues, ConfirmTimeout: 5 * time.Second}
 }
 
-func NewPgInspector(db dlqstore_types.DB, conn dlqstore_types.AMQPConnection, requeueName string) *PostgresInspector {
-	return &PostgresInspector{DB: db, Conn: conn, RequeueName: requeueName}
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
 }
 
-func handleInspect(w http.ResponseWriter, _ *http.Request, inspector dlqstore_types.DLQInspector) {
-	msgs, err := inspector.ListMessages(10)
+func handleInspect(w http.ResponseWriter, req *http.Request, inspector dlqstore_types.DLQInspector) {
+	filter, err := filterFromQuery(req.URL.Query())
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	for _, msg := range msgs {
-		fmt.Printf("ID: %s\nType: %s\nHeaders: %v\nPayload: %s\n\n",
-			msg.ID, msg.Type, msg.Headers, msg.Payload)
+	page, err := inspector.ListMessages(filter)
+	if errors.Is(err, ErrInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		common.LogError(err, "Failed to list DLQ messages")
+		http.Error(w, "could not list messages", http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(msgs)
+	json.NewEncoder(w).Encode(page)
 }
 
 func handleRequeue(w http.ResponseWriter, req *http.Request, inspector dlqstore_types.DLQInspector) {
 	var reqBody dlqstore_types.RequestBody
-	err := json.NewDecoder(req.Body).Decode(&reqBody)
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, constants.MaxRequestBytes)).Decode(&reqBody)
 	if err != nil {
 		common.LogError(err, "Invalid request body")
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
-	if err := inspector.RequeueMessage(reqBody.MessageId); err != nil {
+	if key, ok := auth.FromContext(req.Context()); ok {
+		reqBody.RequestedBy = key.ID
+	}
+	err = inspector.RequeueMessage(reqBody)
+	switch {
+	case errors.Is(err, ErrInvalidPatch), errors.Is(err, ErrInvalidDestination):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrMessageNotFound):
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrRequeueInProgress), errors.Is(err, ErrAlreadyRequeued):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, dlqstore_types.ErrUnroutable):
+		http.Error(w, "Destination has no queue bound", http.StatusBadGateway)
+		return
+	case errors.Is(err, dlqstore_types.ErrNacked):
+		http.Error(w, "Broker rejected the message", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, dlqstore_types.ErrConfirmTimeout):
+		common.LogError(err, "Requeue outcome unknown")
+		http.Error(w, "Timed out waiting for broker confirmation; the message is left in requeuing", http.StatusGatewayTimeout)
+		return
+	case err != nil:
 		common.LogError(err, "Failed to requeue")
 		http.Error(w, "Failed to requeue", http.StatusInternalServerError)
 		return
@@ -130,14 +171,69 @@ func handleRequeue(w http.ResponseWriter, req *http.Request, inspector dlqstore_
 	w.Write([]byte("Notification requeued successfully"))
 }
 
-func StartServer(conn *amqp.Connection, inspector dlqstore_types.DLQInspector, db *pgx.Conn) {
-	defer conn.Close()
-	http.HandleFunc("/inspect", func(w http.ResponseWriter, req *http.Request) {
+func handleBulkRequeue(ctx context.Context, w http.ResponseWriter, req *http.Request, bulk *BulkRequeuer) {
+	var reqBody dlqstore_types.BulkRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, constants.MaxRequestBytes)).Decode(&reqBody)
+	if err != nil {
+		common.LogError(err, "Invalid request body")
+		writeDecodeError(w, err)
+		return
+	}
+	if key, ok := auth.FromContext(req.Context()); ok {
+		reqBody.RequestedBy = key.ID
+	}
+	job, err := bulk.Start(ctx, reqBody)
+	if errors.Is(err, ErrInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		common.LogError(err, "Failed to start bulk requeue")
+		http.Error(w, "could not start bulk requeue", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/requeue/bulk/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func handleBulkStatus(w http.ResponseWriter, req *http.Request, bulk *BulkRequeuer) {
+	job, ok := bulk.Get(req.PathValue("id"))
+	if !ok {
+		http.Error(w, "Bulk requeue job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// StartServer serves the inspector API. A nil authn leaves it unauthenticated.
+func StartServer(ctx context.Context, addr string, conns *connection.Manager, inspector dlqstore_types.DLQInspector, db health.Pinger, authn *auth.Authenticator, shutdownTimeout time.Duration) {
+	defer conns.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/inspect", authn.RequireFunc(auth.ScopeDLQRead, func(w http.ResponseWriter, req *http.Request) {
 		handleInspect(w, req, inspector)
-	})
-	http.HandleFunc("/requeue", func(w http.ResponseWriter, req *http.Request) {
+	}))
+	mux.Handle("/requeue", authn.RequireFunc(auth.ScopeDLQRequeue, func(w http.ResponseWriter, req *http.Request) {
 		handleRequeue(w, req, inspector)
-	})
-	err := http.ListenAndServe(":8091", nil)
-	common.FailOnError(err, "Server failed to start")
+	}))
+	bulk := NewBulkRequeuer(inspector)
+	mux.Handle("POST /requeue/bulk", authn.RequireFunc(auth.ScopeDLQRequeue, func(w http.ResponseWriter, req *http.Request) {
+		handleBulkRequeue(ctx, w, req, bulk)
+	}))
+	mux.Handle("GET /requeue/bulk/{id}", authn.RequireFunc(auth.ScopeDLQRead, func(w http.ResponseWriter, req *http.Request) {
+		handleBulkStatus(w, req, bulk)
+	}))
+	mux.Handle("GET /metrics", metrics.Handler())
+	checker := health.NewChecker()
+	checker.Add("rabbitmq", health.AMQP(conns))
+	checker.Add("channel", health.AMQPChannel(conns))
+	checker.Add("postgres", health.Postgres(db))
+	checker.Register(mux)
+	err := util.Serve(ctx, &http.Server{Addr: addr, Handler: mux}, shutdownTimeout)
+	if ctx.Err() == nil {
+		common.FailOnError(err, "Server failed to start")
+	}
+	common.LogError(err, "Server did not shut down cleanly")
 }
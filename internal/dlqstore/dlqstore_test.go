@@ -3,8 +3,19 @@ package dlqstore
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	"github.com/jayanth-parthsarathy/notify/internal/common/retry"
 	dlqstore_types "github.com/jayanth-parthsarathy/notify/internal/dlqstore/types"
 	"github.com/pashagolub/pgxmock"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -36,6 +47,11 @@ func (m *MockChannel) Publish(exchange, key string, mandatory, immediate bool, m
 	return args.Error(0)
 }
 
+func (m *MockChannel) PublishWithConfirm(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	args := m.Called(exchange, key, msg)
+	return args.Error(0)
+}
+
 func (m *MockChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
 	args := m.Called(prefetchCount, prefetchSize, global)
 	return args.Error(0)
@@ -56,6 +72,8 @@ func (m *MockConnection) Channel() (dlqstore_types.AMQPChannel, error) {
 	return m.ChannelMock, nil
 }
 
+var listColumns = []string{"id", "message_id", "headers", "body", "reason", "error_class", "attempts", "first_seen_at", "original_queue", "status", "requeued_at"}
+
 func TestPostgresInspector_ListMessages(t *testing.T) {
 	mockDB, err := pgxmock.NewConn()
 	assert.NoError(t, err)
@@ -64,26 +82,212 @@ func TestPostgresInspector_ListMessages(t *testing.T) {
 	headers := amqp.Table{"foo": "bar"}
 	hdrBytes, _ := json.Marshal(headers)
 	bodyBytes := []byte(`{"hello":"world"}`)
+	firstSeen := time.Date(2025, 6, 14, 9, 0, 0, 0, time.UTC)
 
-	rows := pgxmock.NewRows([]string{"message_id", "headers", "body"}).
-		AddRow("msg-1", hdrBytes, bodyBytes)
+	rows := pgxmock.NewRows(listColumns).
+		AddRow(int64(9), "msg-1", hdrBytes, bodyBytes, "smtp server replied 550: 5.1.1 unknown user", "permanent", 1, firstSeen, "notification", "stored", nil)
 
-	mockDB.ExpectQuery(`SELECT message_id, headers, body FROM dlq_messages ORDER BY received_at DESC LIMIT \$1`).
-		WithArgs(5).
+	mockDB.ExpectQuery(`SELECT count\(\*\) FROM dlq_messages$`).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
+	mockDB.ExpectQuery(`SELECT id, message_id, headers, body, reason, error_class, attempts, first_seen_at, original_queue, status, requeued_at FROM dlq_messages ORDER BY id DESC LIMIT \$1`).
+		WithArgs(6).
 		WillReturnRows(rows)
 
-	inspector := NewPgInspector(mockDB, nil /* conn */, "unused")
+	inspector := NewPgInspector(mockDB, nil /* conn */, "unused", retry.DefaultPolicy())
 
-	msgs, err := inspector.ListMessages(5)
+	page, err := inspector.ListMessages(dlqstore_types.Filter{Limit: 5})
 	assert.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+	assert.Empty(t, page.NextCursor)
+	msgs := page.Messages
 	assert.Len(t, msgs, 1)
 	assert.Equal(t, "msg-1", msgs[0].ID)
 	assert.Equal(t, `{"hello":"world"}`, msgs[0].Payload)
 	assert.Equal(t, headers, msgs[0].Headers)
+	assert.Equal(t, "permanent", msgs[0].Type)
+	assert.Equal(t, "smtp server replied 550: 5.1.1 unknown user", msgs[0].Reason)
+	assert.Equal(t, 1, msgs[0].Attempts)
+	assert.Equal(t, firstSeen, msgs[0].FirstSeen)
+	assert.Equal(t, "notification", msgs[0].OriginalQueue)
+	assert.Equal(t, dlqstore_types.StatusStored, msgs[0].Status)
+	assert.Nil(t, msgs[0].RequeuedAt)
+
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPostgresInspector_ListMessagesFilteredPage(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	since := time.Date(2025, 6, 14, 9, 0, 0, 0, time.UTC)
+	row := func(id int64) []any {
+		return []any{id, "msg", []byte(`{}`), []byte(`{}`), "", "", 1, since, "", "stored", nil}
+	}
+	mockDB.ExpectQuery(`SELECT count\(\*\) FROM dlq_messages WHERE received_at >= \$1 AND EXISTS \(.+lower\(r.address\) LIKE '%' \|\| lower\(\$2\)\) AND reason ILIKE '%' \|\| \$3 \|\| '%'$`).
+		WithArgs(since, "@example.com", `4.2.2 mailbox\_full`).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(7))
+	mockDB.ExpectQuery(`FROM dlq_messages WHERE .+ AND id < \$4 ORDER BY id DESC LIMIT \$5`).
+		WithArgs(since, "@example.com", `4.2.2 mailbox\_full`, int64(40), 3).
+		WillReturnRows(pgxmock.NewRows(listColumns).AddRow(row(39)...).AddRow(row(35)...).AddRow(row(30)...))
 
+	inspector := NewPgInspector(mockDB, nil, "unused", retry.DefaultPolicy())
+	page, err := inspector.ListMessages(dlqstore_types.Filter{
+		Limit:     2,
+		Cursor:    encodeCursor(40),
+		Since:     since,
+		Recipient: "@example.com",
+		Reason:    "4.2.2 mailbox_full",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, page.Total)
+	assert.Len(t, page.Messages, 2)
+	next, err := decodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(35), next)
 	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
+func TestPostgresInspector_ListMessagesInvalidPath(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectQuery(`SELECT count\(\*\) FROM dlq_messages WHERE jsonb_path_exists\(jsonb_build_object\('body', body, 'headers', headers\), \$1::jsonpath\)`).
+		WithArgs("$.body[").
+		WillReturnError(&pgconn.PgError{Code: "42601", Message: "syntax error at end of jsonpath input"})
+
+	_, err = NewPgInspector(mockDB, nil, "unused", retry.DefaultPolicy()).ListMessages(dlqstore_types.Filter{Path: "$.body["})
+
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	assert.ErrorContains(t, err, "jsonpath")
+}
+
+func TestFilterFromQuery(t *testing.T) {
+	q := url.Values{
+		"limit":      {"25"},
+		"message_id": {"a", "b"},
+		"since":      {"2025-06-14T09:00:00Z"},
+		"until":      {"2025-06-14T10:00:00Z"},
+		"recipient":  {"a@example.com"},
+		"reason":     {"550"},
+		"path":       {`$.headers."x-error-class" ? (@ == "permanent")`},
+		"q":          {"invoice"},
+		"status":     {"requeued"},
+	}
+	f, err := filterFromQuery(q)
+	assert.NoError(t, err)
+	assert.Equal(t, dlqstore_types.Filter{
+		Limit:      25,
+		MessageIDs: []string{"a", "b"},
+		Since:      time.Date(2025, 6, 14, 9, 0, 0, 0, time.UTC),
+		Until:      time.Date(2025, 6, 14, 10, 0, 0, 0, time.UTC),
+		Recipient:  "a@example.com",
+		Reason:     "550",
+		Path:       `$.headers."x-error-class" ? (@ == "permanent")`,
+		Query:      "invoice",
+		Status:     dlqstore_types.StatusRequeued,
+	}, f)
+
+	f, err = filterFromQuery(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultPageSize, f.Limit)
+
+	for _, bad := range []url.Values{
+		{"limit": {"many"}},
+		{"limit": {"1000"}},
+		{"since": {"yesterday"}},
+		{"since": {"2025-06-14T10:00:00Z"}, "until": {"2025-06-14T09:00:00Z"}},
+		{"cursor": {"not a cursor"}},
+		{"status": {"done"}},
+	} {
+		_, err := filterFromQuery(bad)
+		assert.ErrorIs(t, err, ErrInvalidFilter, "%v", bad)
+	}
+}
+
+type fakeInspector struct {
+	filter     dlqstore_types.Filter
+	page       dlqstore_types.Page
+	err        error
+	requeued   dlqstore_types.RequestBody
+	requeueErr error
+}
+
+func (f *fakeInspector) ListMessages(filter dlqstore_types.Filter) (dlqstore_types.Page, error) {
+	f.filter = filter
+	return f.page, f.err
+}
+
+func (f *fakeInspector) RequeueMessage(req dlqstore_types.RequestBody) error {
+	f.requeued = req
+	return f.requeueErr
+}
+
+func TestHandleInspect(t *testing.T) {
+	inspector := &fakeInspector{page: dlqstore_types.Page{
+		Messages:   []dlqstore_types.DeadLetterMessage{{ID: "msg-1"}},
+		NextCursor: "next",
+		Total:      12,
+	}}
+	rec := httptest.NewRecorder()
+	handleInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect?limit=1&q=invoice", nil), inspector)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, dlqstore_types.Filter{Limit: 1, Query: "invoice"}, inspector.filter)
+	var page struct {
+		Messages   []map[string]any `json:"messages"`
+		NextCursor string           `json:"next_cursor"`
+		Total      int              `json:"total"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+	assert.Equal(t, "next", page.NextCursor)
+	assert.Equal(t, 12, page.Total)
+	assert.Equal(t, "msg-1", page.Messages[0]["ID"])
+
+	rec = httptest.NewRecorder()
+	handleInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect?limit=0x", nil), inspector)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	inspector.err = fmt.Errorf("%w: bad path", ErrInvalidFilter)
+	rec = httptest.NewRecorder()
+	handleInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect?path=$.x[", nil), inspector)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	inspector.err = errors.New("db down")
+	rec = httptest.NewRecorder()
+	handleInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect", nil), inspector)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+const (
+	selectClaimSQL = `SELECT id, status, .+ FROM dlq_messages WHERE message_id = \$1 ORDER BY id DESC LIMIT 1`
+	claimSQL       = `UPDATE dlq_messages SET status = \$2, requeue_started_at = now\(\)\s+WHERE id = \$1 AND \(status = \$3 OR`
+)
+
+var claimColumns = []string{"id", "status", "stale", "headers", "body"}
+
+func expectClaim(mockDB pgxmock.PgxConnIface, id int64, headers, body []byte) {
+	mockDB.ExpectQuery(selectClaimSQL).
+		WithArgs("the-id", pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows(claimColumns).AddRow(id, "stored", true, headers, body))
+	mockDB.ExpectExec(claimSQL).
+		WithArgs(id, "requeuing", "stored", false, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+}
+
+func expectFinish(mockDB pgxmock.PgxConnIface, id int64, auditArgs ...any) {
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`INSERT INTO dlq_requeue_audit`).
+		WithArgs(auditArgs...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectExec(`UPDATE dlq_messages SET status = \$2, requeued_at = now\(\) WHERE id = \$1`).
+		WithArgs(id, "requeued").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mockDB.ExpectCommit()
+}
+
 func TestPostgresInspector_RequeueMessage(t *testing.T) {
 	mockDB, err := pgxmock.NewConn()
 	assert.NoError(t, err)
@@ -93,19 +297,11 @@ func TestPostgresInspector_RequeueMessage(t *testing.T) {
 	hdrBytes, _ := json.Marshal(headers)
 	bodyBytes := []byte(`{"ping":"pong"}`)
 
-	mockDB.ExpectQuery(`SELECT headers, body FROM dlq_messages WHERE message_id = \$1`).
-		WithArgs("the-id").
-		WillReturnRows(
-			pgxmock.NewRows([]string{"headers", "body"}).
-				AddRow(hdrBytes, bodyBytes),
-		)
-
-	mockDB.ExpectExec(`DELETE FROM dlq_messages WHERE message_id = \$1`).
-		WithArgs("the-id").
-		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	expectClaim(mockDB, 7, hdrBytes, bodyBytes)
+	expectFinish(mockDB, 7, "the-id", bodyBytes, []byte(nil), "", "retry-queue", "")
 
 	mockCh := &MockChannel{}
-	mockCh.On("Publish", "", "retry-queue", false, false, mock.MatchedBy(func(pub amqp.Publishing) bool {
+	mockCh.On("PublishWithConfirm", "", "retry-queue", mock.MatchedBy(func(pub amqp.Publishing) bool {
 		return string(pub.Body) == string(bodyBytes) &&
 			pub.MessageId == "the-id"
 	})).Return(nil)
@@ -114,11 +310,282 @@ func TestPostgresInspector_RequeueMessage(t *testing.T) {
 	mockConn := &MockConnection{ChannelMock: mockCh}
 	mockConn.On("Channel").Return(mockCh, nil)
 
-	inspector := NewPgInspector(mockDB, mockConn, "retry-queue")
+	inspector := NewPgInspector(mockDB, mockConn, "retry-queue", retry.DefaultPolicy())
 
-	err = inspector.RequeueMessage("the-id")
+	err = inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: "the-id"})
 	assert.NoError(t, err)
 
 	assert.NoError(t, mockDB.ExpectationsWereMet())
 	mockCh.AssertExpectations(t)
 }
+
+func TestPostgresInspector_RequeueMessage_StoredFailureHeaders(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	// Headers as the consumer stores them in dlq_messages.headers.
+	stored := []byte(`{"x-retry-count":3,"x-error-class":"transient","x-last-error":"connection reset",` +
+		`"x-attempts":[{"at":"2025-06-10T09:00:00Z","class":"transient","error":"connection reset"}],` +
+		`"x-death":[{"count":1,"queue":"retry-10s","reason":"expired"}]}`)
+	bodyBytes := []byte(`{"email":"a@example.com","message":"hi"}`)
+	expectClaim(mockDB, 7, stored, bodyBytes)
+	expectFinish(mockDB, 7, "the-id", bodyBytes, []byte(nil), "", "retry-queue", "")
+
+	mockCh := &MockChannel{}
+	mockCh.On("PublishWithConfirm", "", "retry-queue", mock.MatchedBy(func(pub amqp.Publishing) bool {
+		attempts, _ := pub.Headers["x-attempts"].([]any)
+		_, hasDeath := pub.Headers["x-death"]
+		return pub.Headers.Validate() == nil && len(attempts) == 1 && !hasDeath
+	})).Return(nil)
+	mockCh.On("Close").Return(nil)
+	mockConn := &MockConnection{ChannelMock: mockCh}
+	mockConn.On("Channel").Return(mockCh, nil)
+
+	inspector := NewPgInspector(mockDB, mockConn, "retry-queue", retry.DefaultPolicy())
+	err = inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: "the-id"})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	mockCh.AssertExpectations(t)
+}
+
+func TestPostgresInspector_RequeueMessage_Patched(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	original := []byte(`{"email":"bob@exmaple..com","subject":"Hi","message":"Hello"}`)
+	edited := []byte(`{"email":"bob@example.com","message":"Hello","subject":"Hi"}`)
+	expectClaim(mockDB, 7, []byte(`{}`), original)
+	expectFinish(mockDB, 7, "the-id", original, edited, constants.RetryExchangeName, "retry-30s", "key-1")
+
+	mockCh := &MockChannel{}
+	mockCh.On("PublishWithConfirm", constants.RetryExchangeName, "retry-30s", mock.MatchedBy(func(pub amqp.Publishing) bool {
+		return string(pub.Body) == string(edited)
+	})).Return(nil)
+	mockCh.On("Close").Return(nil)
+	mockConn := &MockConnection{ChannelMock: mockCh}
+	mockConn.On("Channel").Return(mockCh, nil)
+
+	inspector := NewPgInspector(mockDB, mockConn, "retry-queue", retry.DefaultPolicy())
+	err = inspector.RequeueMessage(dlqstore_types.RequestBody{
+		MessageId:   "the-id",
+		Patch:       json.RawMessage(`{"email":"bob@example.com"}`),
+		Exchange:    constants.RetryExchangeName,
+		RoutingKey:  "retry-30s",
+		RequestedBy: "key-1",
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	mockCh.AssertExpectations(t)
+}
+
+func TestPostgresInspector_RequeueMessage_InvalidPatch(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectQuery(selectClaimSQL).
+		WithArgs("the-id", pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows(claimColumns).
+			AddRow(int64(7), "stored", true, []byte(`{}`), []byte(`{"email":"bob@example.com","message":"Hello"}`)))
+	mockDB.ExpectQuery(selectClaimSQL).
+		WithArgs("missing", pgxmock.AnyArg()).
+		WillReturnError(pgx.ErrNoRows)
+
+	inspector := NewPgInspector(mockDB, &MockConnection{}, "retry-queue", retry.DefaultPolicy())
+	err = inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: "the-id", Patch: json.RawMessage(`{"email":"not-an-address"}`)})
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+
+	err = inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: "missing"})
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPostgresInspector_RequeueMessage_Conflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		stale  bool
+		force  bool
+		want   error
+	}{
+		{name: "already requeued", status: "requeued", want: ErrAlreadyRequeued},
+		{name: "claimed", status: "requeuing", stale: true, want: ErrRequeueInProgress},
+		{name: "claimed recently, forced", status: "requeuing", force: true, want: ErrRequeueInProgress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, err := pgxmock.NewConn()
+			assert.NoError(t, err)
+			defer mockDB.Close(context.Background())
+
+			mockDB.ExpectQuery(selectClaimSQL).
+				WithArgs("the-id", pgxmock.AnyArg()).
+				WillReturnRows(pgxmock.NewRows(claimColumns).AddRow(int64(7), tt.status, tt.stale, []byte(`{}`), []byte(`{}`)))
+
+			err = NewPgInspector(mockDB, &MockConnection{}, "retry-queue", retry.DefaultPolicy()).
+				RequeueMessage(dlqstore_types.RequestBody{MessageId: "the-id", Force: tt.force})
+			assert.ErrorIs(t, err, tt.want)
+			assert.NoError(t, mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+// Two requeues that both read the entry as stored race on the conditional
+// UPDATE; Postgres lets only the first one match the row.
+func TestPostgresInspector_ClaimRace(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+	mockDB.MatchExpectationsInOrder(false)
+
+	for _, affected := range []int64{1, 0} {
+		mockDB.ExpectQuery(selectClaimSQL).WithArgs("the-id", pgxmock.AnyArg()).
+			WillReturnRows(pgxmock.NewRows(claimColumns).AddRow(int64(7), "stored", true, []byte(`{}`), []byte(`{}`)))
+		mockDB.ExpectExec(claimSQL).
+			WithArgs(int64(7), "requeuing", "stored", false, pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("UPDATE", affected))
+	}
+
+	inspector := NewPgInspector(mockDB, &MockConnection{}, "retry-queue", retry.DefaultPolicy())
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := inspector.claim(context.Background(), dlqstore_types.RequestBody{MessageId: "the-id"})
+			errs <- err
+		}()
+	}
+	var claimed, rejected int
+	for range 2 {
+		switch err := <-errs; {
+		case err == nil:
+			claimed++
+		case errors.Is(err, ErrRequeueInProgress):
+			rejected++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 1, claimed)
+	assert.Equal(t, 1, rejected)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPostgresInspector_RequeueMessage_PublishFailure(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	expectClaim(mockDB, 7, []byte(`{}`), []byte(`{}`))
+	mockDB.ExpectExec(`UPDATE dlq_messages SET status = \$2, requeue_started_at = NULL WHERE id = \$1 AND status = \$3`).
+		WithArgs(int64(7), "stored", "requeuing").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	expectClaim(mockDB, 7, []byte(`{}`), []byte(`{}`))
+
+	mockCh := &MockChannel{}
+	mockCh.On("PublishWithConfirm", "", "retry-queue", mock.Anything).Return(dlqstore_types.ErrNacked).Once()
+	mockCh.On("PublishWithConfirm", "", "retry-queue", mock.Anything).Return(dlqstore_types.ErrConfirmTimeout).Once()
+	mockCh.On("Close").Return(nil)
+	mockConn := &MockConnection{ChannelMock: mockCh}
+	mockConn.On("Channel").Return(mockCh, nil)
+	inspector := NewPgInspector(mockDB, mockConn, "retry-queue", retry.DefaultPolicy())
+
+	// A nack puts the entry back to stored.
+	err = inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: "the-id"})
+	assert.ErrorIs(t, err, dlqstore_types.ErrNacked)
+
+	// Without a confirm the outcome is unknown, so the entry stays claimed.
+	err = inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: "the-id"})
+	assert.ErrorIs(t, err, dlqstore_types.ErrConfirmTimeout)
+
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	mockCh.AssertExpectations(t)
+}
+
+func TestMergePatch(t *testing.T) {
+	var doc, patch any
+	assert.NoError(t, json.Unmarshal([]byte(`{"subject":"Hi","data":{"name":"Bob","plan":"pro"},"cc":["a@example.com"]}`), &doc))
+	assert.NoError(t, json.Unmarshal([]byte(`{"subject":"Hello","data":{"name":"Robert","plan":null},"cc":[]}`), &patch))
+	merged, err := json.Marshal(mergePatch(doc, patch))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"subject":"Hello","data":{"name":"Robert"},"cc":[]}`, string(merged))
+}
+
+func TestApplyPatch_KeepsSender(t *testing.T) {
+	stored := []byte(`{"email":"a@example.com","from":"billing@example.com","message":"hi"}`)
+
+	_, err := applyPatch(stored, json.RawMessage(`{"message":"hello"}`))
+	assert.NoError(t, err)
+
+	_, err = applyPatch(stored, json.RawMessage(`{"from":"ceo@example.com"}`))
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+}
+
+func TestHandleRequeue(t *testing.T) {
+	inspector := &fakeInspector{}
+	rec := httptest.NewRecorder()
+	body := `{"messageId":"msg-1","patch":{"subject":"Fixed"},"routing_key":"other"}`
+	handleRequeue(rec, httptest.NewRequest(http.MethodPost, "/requeue", strings.NewReader(body)), inspector)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "msg-1", inspector.requeued.MessageId)
+	assert.JSONEq(t, `{"subject":"Fixed"}`, string(inspector.requeued.Patch))
+	assert.Equal(t, "other", inspector.requeued.RoutingKey)
+
+	inspector.requeueErr = fmt.Errorf("%w: email: invalid address", ErrInvalidPatch)
+	rec = httptest.NewRecorder()
+	handleRequeue(rec, httptest.NewRequest(http.MethodPost, "/requeue", strings.NewReader(body)), inspector)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	inspector.requeueErr = fmt.Errorf("%w: msg-1", ErrMessageNotFound)
+	rec = httptest.NewRecorder()
+	handleRequeue(rec, httptest.NewRequest(http.MethodPost, "/requeue", strings.NewReader(body)), inspector)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	inspector.requeueErr = fmt.Errorf("%w: msg-1", ErrRequeueInProgress)
+	rec = httptest.NewRecorder()
+	handleRequeue(rec, httptest.NewRequest(http.MethodPost, "/requeue", strings.NewReader(body)), inspector)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	inspector.requeueErr = fmt.Errorf("message msg-1 left in requeuing: %w", dlqstore_types.ErrConfirmTimeout)
+	rec = httptest.NewRecorder()
+	handleRequeue(rec, httptest.NewRequest(http.MethodPost, "/requeue", strings.NewReader(body)), inspector)
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	inspector.requeueErr = fmt.Errorf("%w: \"\"/\"other\"", ErrInvalidDestination)
+	rec = httptest.NewRecorder()
+	handleRequeue(rec, httptest.NewRequest(http.MethodPost, "/requeue", strings.NewReader(body)), inspector)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	oversized := `{"messageId":"msg-1","patch":{"message":"` + strings.Repeat("a", constants.MaxRequestBytes) + `"}}`
+	rec = httptest.NewRecorder()
+	handleRequeue(rec, httptest.NewRequest(http.MethodPost, "/requeue", strings.NewReader(oversized)), inspector)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestPostgresInspector_RequeueMessage_RejectsOtherDestinations(t *testing.T) {
+	inspector := NewPgInspector(nil, nil, constants.MainQueueName, retry.DefaultPolicy())
+	cases := []struct {
+		exchange   string
+		routingKey string
+	}{
+		{"", "notification.priority"},
+		{"", constants.DLQName},
+		{"amq.topic", constants.MainQueueName},
+		{constants.RetryExchangeName, constants.MainQueueName},
+		{constants.RetryExchangeName, "retry-5s"},
+	}
+	for _, tc := range cases {
+		err := inspector.RequeueMessage(dlqstore_types.RequestBody{MessageId: "the-id", Exchange: tc.exchange, RoutingKey: tc.routingKey})
+		assert.ErrorIs(t, err, ErrInvalidDestination, "%q/%q", tc.exchange, tc.routingKey)
+	}
+
+	exchange, routingKey, err := inspector.destination(dlqstore_types.RequestBody{Exchange: constants.RetryExchangeName, RoutingKey: "retry-10s"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{constants.RetryExchangeName, "retry-10s"}, []string{exchange, routingKey})
+	_, routingKey, err = inspector.destination(dlqstore_types.RequestBody{})
+	assert.NoError(t, err)
+	assert.Equal(t, constants.MainQueueName, routingKey)
+}
@@ -0,0 +1,163 @@
+package dlqstore
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jayanth-parthsarathy/notify/internal/dlqstore/types"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+func invalidFilter(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, args...))
+}
+
+// filterFromQuery reads a filter from the /inspect query string.
+func filterFromQuery(q url.Values) (dlqstore_types.Filter, error) {
+	f := dlqstore_types.Filter{
+		Cursor:     q.Get("cursor"),
+		MessageIDs: q["message_id"],
+		Recipient:  q.Get("recipient"),
+		Reason:     q.Get("reason"),
+		Path:       q.Get("path"),
+		Query:      q.Get("q"),
+		Status:     q.Get("status"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return f, invalidFilter("limit must be an integer")
+		}
+		f.Limit = limit
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, invalidFilter("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = t
+		}
+	}
+	return f, validateFilter(&f)
+}
+
+func validateFilter(f *dlqstore_types.Filter) error {
+	switch {
+	case f.Limit == 0:
+		f.Limit = defaultPageSize
+	case f.Limit < 0 || f.Limit > maxPageSize:
+		return invalidFilter("limit must be between 1 and %d", maxPageSize)
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && !f.Since.Before(f.Until) {
+		return invalidFilter("since must be before until")
+	}
+	switch f.Status {
+	case "", dlqstore_types.StatusStored, dlqstore_types.StatusRequeuing, dlqstore_types.StatusRequeued:
+	default:
+		return invalidFilter("status must be %s, %s or %s", dlqstore_types.StatusStored, dlqstore_types.StatusRequeuing, dlqstore_types.StatusRequeued)
+	}
+	if _, err := decodeCursor(f.Cursor); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Cursors are the id of the last row on the previous page. Rows are listed
+// newest first, so the next page continues below it.
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, invalidFilter("malformed cursor")
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, invalidFilter("malformed cursor")
+	}
+	return id, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+const recipientsSQL = `jsonb_array_elements_text(
+	COALESCE(body->'to', '[]') || COALESCE(body->'cc', '[]') || COALESCE(body->'bcc', '[]') ||
+	jsonb_build_array(body->>'email', body->>'phone'))`
+
+// searchSQL must match the expression of dlq_messages_search_idx.
+const searchSQL = `to_tsvector('english', COALESCE(body->>'subject', '') || ' ' || COALESCE(body->>'message', ''))`
+
+// whereClause renders the conditions of f, without the cursor, as a WHERE
+// clause with numbered placeholders starting at $1.
+func whereClause(f dlqstore_types.Filter) (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if len(f.MessageIDs) > 0 {
+		conds = append(conds, "message_id = ANY("+arg(f.MessageIDs)+")")
+	}
+	if f.Status != "" {
+		conds = append(conds, "status = "+arg(f.Status))
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "received_at >= "+arg(f.Since))
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "received_at < "+arg(f.Until))
+	}
+	if f.Recipient != "" {
+		var match string
+		if strings.HasPrefix(f.Recipient, "@") {
+			match = "lower(r.address) LIKE '%' || lower(" + arg(escapeLike(f.Recipient)) + ")"
+		} else {
+			match = "lower(r.address) = lower(" + arg(f.Recipient) + ")"
+		}
+		conds = append(conds, "EXISTS (SELECT 1 FROM "+recipientsSQL+" AS r(address) WHERE "+match+")")
+	}
+	if f.Reason != "" {
+		conds = append(conds, "reason ILIKE '%' || "+arg(escapeLike(f.Reason))+" || '%'")
+	}
+	if f.Path != "" {
+		conds = append(conds, "jsonb_path_exists(jsonb_build_object('body', body, 'headers', headers), "+arg(f.Path)+"::jsonpath)")
+	}
+	if f.Query != "" {
+		conds = append(conds, searchSQL+" @@ websearch_to_tsquery('english', "+arg(f.Query)+")")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// queryError reports errors caused by the filter itself, such as a malformed
+// JSON path, as ErrInvalidFilter.
+func queryError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || pgErr.Code == "42601") {
+		return invalidFilter("%s", pgErr.Message)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package dlqstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+)
+
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// applyPatch merges patch into the stored body and checks the result the way
+// the producer checks a notification, returning the body to publish.
+func applyPatch(body []byte, patch json.RawMessage) ([]byte, error) {
+	var doc, changes any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stored body: %w", err)
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	if _, ok := changes.(map[string]any); !ok {
+		return nil, fmt.Errorf("%w: must be a JSON object", ErrInvalidPatch)
+	}
+	merged, err := json.Marshal(mergePatch(doc, changes))
+	if err != nil {
+		return nil, err
+	}
+
+	var original, n types.RequestBody
+	if err := json.Unmarshal(body, &original); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stored body: %w", err)
+	}
+	if err := json.NewDecoder(bytes.NewReader(merged)).Decode(&n); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	// A patch may keep the sender the producer accepted but not pick another.
+	if err := n.Validate([]string{original.From}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	return json.Marshal(n)
+}
+
+// mergePatch implements RFC 7396: objects merge key by key, null removes a
+// key and any other value replaces the target.
+func mergePatch(target, patch any) any {
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]any)
+	if !ok {
+		doc = map[string]any{}
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		doc[k] = mergePatch(doc[k], v)
+	}
+	return doc
+}
@@ -0,0 +1,237 @@
+package dlqstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	"github.com/jayanth-parthsarathy/notify/internal/common/metrics"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
+	"github.com/jayanth-parthsarathy/notify/internal/dlqstore/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+
+	common "github.com/jayanth-parthsarathy/notify/internal/common/log"
+)
+
+var (
+	ErrMessageNotFound    = errors.New("message not found")
+	ErrRequeueInProgress  = errors.New("message is already being requeued")
+	ErrAlreadyRequeued    = errors.New("message was already requeued")
+	ErrInvalidDestination = errors.New("requeue destination is not allowed")
+)
+
+// staleClaimGrace is how long past the confirm timeout a requeuing entry
+// must wait before Force may reclaim it.
+const staleClaimGrace = time.Minute
+
+// claim is a DLQ entry that a requeue has moved to requeuing.
+type claim struct {
+	id       int64
+	headers  amqp.Table
+	original []byte
+	body     []byte
+	edited   []byte
+}
+
+// RequeueMessage claims the newest entry for the message, publishes it with
+// publisher confirms and marks it requeued. The entry is kept for history.
+//
+// A failed publish returns the entry to stored. When the confirm times out the
+// broker may or may not have the message, so the entry stays in requeuing and
+// only a Force requeue, once the claim is stale, can take it again.
+func (p *PostgresInspector) RequeueMessage(req dlqstore_types.RequestBody) (err error) {
+	defer func() {
+		metrics.DLQRequeues.WithLabelValues(metrics.Result(err)).Inc()
+	}()
+	exchange, routingKey, err := p.destination(req)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	c, err := p.claim(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	err = p.publish(ctx, req.MessageId, c, exchange, routingKey)
+	if errors.Is(err, dlqstore_types.ErrConfirmTimeout) {
+		return fmt.Errorf("message %s left in %s: %w", req.MessageId, dlqstore_types.StatusRequeuing, err)
+	}
+	if err != nil {
+		p.release(ctx, c.id)
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	if err := p.finish(ctx, req, c, exchange, routingKey); err != nil {
+		return fmt.Errorf("message %s was published but left in %s: %w", req.MessageId, dlqstore_types.StatusRequeuing, err)
+	}
+	logrus.Infof("Message %s requeued successfully to %q/%q", req.MessageId, exchange, routingKey)
+	return nil
+}
+
+// destination resolves where a requeue publishes: RequeueName by default, or
+// one of the retry queues, named directly or through the retry exchange.
+func (p *PostgresInspector) destination(req dlqstore_types.RequestBody) (string, string, error) {
+	exchange, routingKey := req.Exchange, req.RoutingKey
+	if exchange == "" && routingKey == "" {
+		return "", p.RequeueName, nil
+	}
+	switch {
+	case exchange == "" && routingKey == p.RequeueName:
+	case (exchange == "" || exchange == constants.RetryExchangeName) && slices.Contains(p.RetryQueues, routingKey):
+	default:
+		return "", "", fmt.Errorf("%w: %q/%q", ErrInvalidDestination, exchange, routingKey)
+	}
+	return exchange, routingKey, nil
+}
+
+// claim moves the newest entry for the message from stored to requeuing.
+// The move is a single conditional UPDATE, so of two requeues racing for the
+// same entry only one matches the row; the other is rejected rather than
+// waiting for the outcome.
+func (p *PostgresInspector) claim(ctx context.Context, req dlqstore_types.RequestBody) (claim, error) {
+	var c claim
+	var status string
+	var stale bool
+	var headersJSON []byte
+	staleAfter := (p.ConfirmTimeout + staleClaimGrace).Seconds()
+	err := p.DB.QueryRow(ctx,
+		`SELECT id, status, COALESCE(requeue_started_at < now() - make_interval(secs => $2), true), headers, body
+		FROM dlq_messages WHERE message_id = $1 ORDER BY id DESC LIMIT 1`,
+		req.MessageId, staleAfter,
+	).Scan(&c.id, &status, &stale, &headersJSON, &c.original)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, fmt.Errorf("%w: %s", ErrMessageNotFound, req.MessageId)
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to fetch message from db: %w", err)
+	}
+
+	reclaim := req.Force && stale
+	switch status {
+	case dlqstore_types.StatusRequeued:
+		return c, fmt.Errorf("%w: %s", ErrAlreadyRequeued, req.MessageId)
+	case dlqstore_types.StatusRequeuing:
+		if !reclaim {
+			return c, fmt.Errorf("%w: %s", ErrRequeueInProgress, req.MessageId)
+		}
+		logrus.Warnf("Reclaiming message %s from a requeue that never finished", req.MessageId)
+	}
+
+	c.body = c.original
+	if len(req.Patch) > 0 {
+		if c.body, err = applyPatch(c.original, req.Patch); err != nil {
+			return c, err
+		}
+		c.edited = c.body
+	}
+	if err := json.Unmarshal(headersJSON, &c.headers); err != nil {
+		return c, fmt.Errorf("failed to unmarshal headers: %w", err)
+	}
+
+	tag, err := p.DB.Exec(ctx,
+		`UPDATE dlq_messages SET status = $2, requeue_started_at = now()
+		WHERE id = $1 AND (status = $3 OR ($4 AND status = $2 AND requeue_started_at < now() - make_interval(secs => $5)))`,
+		c.id, dlqstore_types.StatusRequeuing, dlqstore_types.StatusStored, reclaim, staleAfter,
+	)
+	if err != nil {
+		return c, err
+	}
+	if tag.RowsAffected() == 0 {
+		return c, fmt.Errorf("%w: %s", ErrRequeueInProgress, req.MessageId)
+	}
+	return c, nil
+}
+
+func (p *PostgresInspector) publish(ctx context.Context, messageId string, c claim, exchange, routingKey string) (err error) {
+	ch, err := p.Conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	_, span := tracing.Tracer().Start(tracing.Extract(ctx, c.headers), "requeue dead letter",
+		trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(tracing.MessageID(messageId)))
+	defer func() { tracing.End(span, err) }()
+
+	clean := make(amqp.Table, len(c.headers))
+	for k, v := range c.headers {
+		if k == "x-death" {
+			continue
+		}
+		clean[k] = toAMQP(v)
+	}
+	tracing.Inject(trace.ContextWithSpan(ctx, span), clean)
+
+	confirmCtx, cancel := context.WithTimeout(ctx, p.ConfirmTimeout)
+	defer cancel()
+	return ch.PublishWithConfirm(confirmCtx, exchange, routingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		Body:         c.body,
+		Headers:      clean,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    messageId,
+	})
+}
+
+// toAMQP restores the nested tables in a header value read back from JSON,
+// such as the entries of x-attempts, which amqp091 refuses as plain maps.
+func toAMQP(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		t := make(amqp.Table, len(v))
+		for k, e := range v {
+			t[k] = toAMQP(e)
+		}
+		return t
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = toAMQP(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// release returns a claimed entry to stored after the broker refused it.
+func (p *PostgresInspector) release(ctx context.Context, id int64) {
+	_, err := p.DB.Exec(ctx,
+		`UPDATE dlq_messages SET status = $2, requeue_started_at = NULL WHERE id = $1 AND status = $3`,
+		id, dlqstore_types.StatusStored, dlqstore_types.StatusRequeuing,
+	)
+	common.LogError(err, fmt.Sprintf("Failed to release DLQ entry %d", id))
+}
+
+// finish records the audit entry and marks the entry requeued together.
+func (p *PostgresInspector) finish(ctx context.Context, req dlqstore_types.RequestBody, c claim, exchange, routingKey string) error {
+	tx, err := p.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx,
+		`INSERT INTO dlq_requeue_audit (message_id, original_body, edited_body, exchange, routing_key, requested_by)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		req.MessageId, c.original, c.edited, exchange, routingKey, req.RequestedBy,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record requeue audit: %w", err)
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE dlq_messages SET status = $2, requeued_at = now() WHERE id = $1`,
+		c.id, dlqstore_types.StatusRequeued,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -2,34 +2,113 @@ package dlqstore_types
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// A stored DLQ entry moves from stored to requeuing when a requeue claims it
+// and to requeued once the broker has confirmed the republished message.
+const (
+	StatusStored    = "stored"
+	StatusRequeuing = "requeuing"
+	StatusRequeued  = "requeued"
+)
+
+// DeadLetterMessage is a stored DLQ entry. Type is the error class of the
+// last failure: permanent, transient or rate_limited.
 type DeadLetterMessage struct {
-	ID      string
-	Headers amqp.Table
-	Payload string
-	Type    string
-	Raw     []byte
+	ID            string
+	Headers       amqp.Table
+	Payload       string
+	Type          string
+	Raw           []byte
+	Reason        string
+	Attempts      int
+	FirstSeen     time.Time
+	OriginalQueue string
+	Status        string
+	RequeuedAt    *time.Time
+}
+
+// Filter selects stored DLQ entries. Zero fields match everything.
+type Filter struct {
+	Limit      int      `json:"limit,omitempty"`
+	Cursor     string   `json:"cursor,omitempty"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+	// Since and Until bound received_at, Until exclusive.
+	Since time.Time `json:"since,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+	// Recipient matches an email address or phone number exactly, or every
+	// address at a domain when it starts with "@".
+	Recipient string `json:"recipient,omitempty"`
+	// Reason matches a substring of the failure reason, case-insensitively.
+	Reason string `json:"reason,omitempty"`
+	// Path is a SQL/JSON path evaluated against {"body": ..., "headers": ...}.
+	Path string `json:"path,omitempty"`
+	// Query is a full-text search over the subject and message.
+	Query string `json:"q,omitempty"`
+	// Status is one of stored, requeuing or requeued.
+	Status string `json:"status,omitempty"`
+}
+
+// Page is one page of DLQ entries. Total counts every entry matching the
+// filter, and NextCursor is empty on the last page.
+type Page struct {
+	Messages   []DeadLetterMessage `json:"messages"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	Total      int                 `json:"total"`
 }
 
 type DLQInspector interface {
-	ListMessages(limit int) ([]DeadLetterMessage, error)
-	RequeueMessage(messageId string) error
+	ListMessages(filter Filter) (Page, error)
+	RequeueMessage(req RequestBody) error
 }
 
+// RequestBody is a /requeue request. Patch is a JSON merge patch (RFC 7396)
+// applied to the stored body before it is published. Exchange and RoutingKey
+// override the destination, which defaults to the main queue. Force reclaims
+// an entry left in requeuing by a requeue that never finished.
 type RequestBody struct {
-	MessageId string
+	MessageId  string
+	Patch      json.RawMessage `json:"patch,omitempty"`
+	Exchange   string          `json:"exchange,omitempty"`
+	RoutingKey string          `json:"routing_key,omitempty"`
+	Force      bool            `json:"force,omitempty"`
+	// RequestedBy is the API key that asked for the requeue, for the audit trail.
+	RequestedBy string `json:"-"`
 }
 
+// BulkRequest selects messages to requeue either by ID or by the filter
+// /inspect takes. Only stored entries are requeued, so Limit, Cursor and
+// Status of the filter are ignored.
+type BulkRequest struct {
+	MessageIDs    []string `json:"message_ids,omitempty"`
+	Filter        *Filter  `json:"filter,omitempty"`
+	DryRun        bool     `json:"dry_run"`
+	RatePerSecond int      `json:"rate_per_second,omitempty"`
+	RequestedBy   string   `json:"-"`
+}
+
+var (
+	ErrUnroutable     = errors.New("message could not be routed to a queue")
+	ErrNacked         = errors.New("broker rejected the message")
+	ErrConfirmTimeout = errors.New("timed out waiting for broker confirmation")
+)
+
 type AMQPChannel interface {
 	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
 	Nack(tag uint64, multiple, requeue bool) error
 	Ack(tag uint64, multiple bool) error
 	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	// PublishWithConfirm publishes a mandatory message and waits until ctx
+	// is done for the broker to confirm it.
+	PublishWithConfirm(ctx context.Context, exchange, key string, msg amqp.Publishing) error
 	Qos(prefetchCount, prefetchSize int, global bool) error
 	Close() error
 }
@@ -39,19 +118,24 @@ type AMQPConnection interface {
 }
 
 type realAMQPConnection struct {
-	conn *amqp.Connection
+	conns *connection.Manager
 }
 
 type realAMQPChannel struct {
-	ch *amqp.Channel
+	ch      *amqp.Channel
+	returns chan amqp.Return
 }
 
-func NewConnectionAdapter(conn *amqp.Connection) *realAMQPConnection {
-	return &realAMQPConnection{conn: conn}
+func NewConnectionAdapter(conns *connection.Manager) *realAMQPConnection {
+	return &realAMQPConnection{conns: conns}
 }
 
 func (r *realAMQPConnection) Channel() (AMQPChannel, error) {
-	ch, err := r.conn.Channel()
+	conn, err := r.conns.Current()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
 	if err != nil {
 		return nil, err
 	}
@@ -72,6 +156,43 @@ func (c *realAMQPChannel) Ack(tag uint64, multiple bool) error {
 func (c *realAMQPChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
 	return c.ch.Publish(exchange, key, mandatory, immediate, msg)
 }
+
+// The channel switches to confirm mode on first use. The broker sends
+// basic.return before the ack for an unroutable mandatory message, so once the
+// confirm arrives any matching return is already queued.
+func (c *realAMQPChannel) PublishWithConfirm(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if c.returns == nil {
+		if err := c.ch.Confirm(false); err != nil {
+			return err
+		}
+		c.returns = c.ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	confirm, err := c.ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrConfirmTimeout
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case r := <-c.returns:
+			if r.MessageId == msg.MessageId {
+				return ErrUnroutable
+			}
+		default:
+			if !acked {
+				return ErrNacked
+			}
+			return nil
+		}
+	}
+}
+
 func (c *realAMQPChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
 	return c.ch.Qos(prefetchCount, prefetchSize, global)
 }
@@ -80,6 +201,7 @@ func (c *realAMQPChannel) Close() error {
 }
 
 type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
 	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
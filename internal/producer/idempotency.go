@@ -0,0 +1,124 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	producer_types "github.com/jayanth-parthsarathy/notify/internal/producer/types"
+)
+
+type idempotencyEntry struct {
+	messageId   string
+	fingerprint string
+	expiresAt   time.Time
+}
+
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]idempotencyEntry
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]idempotencyEntry), now: time.Now}
+}
+
+func (m *MemoryIdempotencyStore) Reserve(_ context.Context, key string, fingerprint string, messageId string, window time.Duration) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.purge(now)
+	if entry, ok := m.entries[key]; ok && now.Before(entry.expiresAt) {
+		if entry.fingerprint != fingerprint {
+			return "", false, producer_types.ErrIdempotencyKeyReused
+		}
+		return entry.messageId, false, nil
+	}
+	m.entries[key] = idempotencyEntry{messageId: messageId, fingerprint: fingerprint, expiresAt: now.Add(window)}
+	return messageId, true, nil
+}
+
+func (m *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *MemoryIdempotencyStore) purge(now time.Time) {
+	if now.Sub(m.lastPurge) < time.Minute {
+		return
+	}
+	for key, entry := range m.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(m.entries, key)
+		}
+	}
+	m.lastPurge = now
+}
+
+type PgIdempotencyStore struct {
+	DB producer_types.DB
+
+	mu        sync.Mutex
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func NewPgIdempotencyStore(db producer_types.DB) *PgIdempotencyStore {
+	return &PgIdempotencyStore{DB: db, now: time.Now}
+}
+
+func (p *PgIdempotencyStore) Reserve(ctx context.Context, key string, fingerprint string, messageId string, window time.Duration) (string, bool, error) {
+	p.purge(ctx)
+	var stored, storedFingerprint string
+	err := p.DB.QueryRow(ctx,
+		`INSERT INTO idempotency_keys (key, message_id, fingerprint, expires_at) VALUES ($1, $2, $3, now() + $4 * interval '1 second')
+		ON CONFLICT (key) DO UPDATE SET message_id = EXCLUDED.message_id, fingerprint = EXCLUDED.fingerprint, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= now()
+		RETURNING message_id`,
+		key, messageId, fingerprint, window.Seconds(),
+	).Scan(&stored)
+	if err == nil {
+		return stored, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", false, err
+	}
+	err = p.DB.QueryRow(ctx, `SELECT message_id, fingerprint FROM idempotency_keys WHERE key = $1`, key).Scan(&stored, &storedFingerprint)
+	if err != nil {
+		return "", false, err
+	}
+	if storedFingerprint != fingerprint {
+		return "", false, producer_types.ErrIdempotencyKeyReused
+	}
+	return stored, false, nil
+}
+
+func (p *PgIdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := p.DB.Exec(ctx, `DELETE FROM idempotency_keys WHERE key = $1`, key)
+	return err
+}
+
+// purge deletes expired keys at most once a minute. Reserve only overwrites an
+// expired key when it is reused, so without it the table keeps every key ever
+// sent.
+func (p *PgIdempotencyStore) purge(ctx context.Context) {
+	p.mu.Lock()
+	now := p.now()
+	due := now.Sub(p.lastPurge) >= time.Minute
+	if due {
+		p.lastPurge = now
+	}
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+	_, err := p.DB.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+	logs.LogError(err, "Failed to purge expired idempotency keys")
+}
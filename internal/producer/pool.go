@@ -0,0 +1,121 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
+	producer_types "github.com/jayanth-parthsarathy/notify/internal/producer/types"
+)
+
+type ChannelPool struct {
+	Open           func() (producer_types.PooledChannel, error)
+	AcquireTimeout time.Duration
+	idle           chan producer_types.PooledChannel
+	slots          chan struct{}
+}
+
+func NewChannelPool(size int, acquireTimeout time.Duration, open func() (producer_types.PooledChannel, error)) *ChannelPool {
+	if size < 1 {
+		size = 1
+	}
+	return &ChannelPool{
+		Open:           open,
+		AcquireTimeout: acquireTimeout,
+		idle:           make(chan producer_types.PooledChannel, size),
+		slots:          make(chan struct{}, size),
+	}
+}
+
+func OpenConfirmChannel(conns *connection.Manager, confirmTimeout time.Duration) func() (producer_types.PooledChannel, error) {
+	return func() (producer_types.PooledChannel, error) {
+		conn, err := conns.Current()
+		if err != nil {
+			return nil, err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+		confirmCh, err := connection.NewConfirmChannel(ch, confirmTimeout)
+		if err != nil {
+			ch.Close()
+			return nil, err
+		}
+		return confirmCh, nil
+	}
+}
+
+// Get prefers an idle channel, opens a new one while the pool is below its
+// size, or waits up to AcquireTimeout for one to be returned. Closing the
+// returned channel gives it back to the pool; channels the broker closed are
+// discarded instead.
+func (p *ChannelPool) Get(ctx context.Context) (producer_types.Channel, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.AcquireTimeout)
+	defer cancel()
+	for {
+		select {
+		case ch := <-p.idle:
+			if pooled := p.checkOut(ch); pooled != nil {
+				return pooled, nil
+			}
+			continue
+		default:
+		}
+		select {
+		case ch := <-p.idle:
+			if pooled := p.checkOut(ch); pooled != nil {
+				return pooled, nil
+			}
+		case p.slots <- struct{}{}:
+			ch, err := p.Open()
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			return &pooledChannel{PooledChannel: ch, pool: p}, nil
+		case <-ctx.Done():
+			return nil, producer_types.ErrPoolExhausted
+		}
+	}
+}
+
+func (p *ChannelPool) checkOut(ch producer_types.PooledChannel) producer_types.Channel {
+	if ch.IsClosed() {
+		<-p.slots
+		return nil
+	}
+	return &pooledChannel{PooledChannel: ch, pool: p}
+}
+
+func (p *ChannelPool) put(ch producer_types.PooledChannel) {
+	if ch.IsClosed() {
+		<-p.slots
+		return
+	}
+	p.idle <- ch
+}
+
+func (p *ChannelPool) Close() {
+	for {
+		select {
+		case ch := <-p.idle:
+			ch.Close()
+			<-p.slots
+		default:
+			return
+		}
+	}
+}
+
+type pooledChannel struct {
+	producer_types.PooledChannel
+	pool *ChannelPool
+	once sync.Once
+}
+
+func (c *pooledChannel) Close() error {
+	c.once.Do(func() { c.pool.put(c.PooledChannel) })
+	return nil
+}
@@ -2,84 +2,460 @@ package producer
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
 	constants "github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	"github.com/jayanth-parthsarathy/notify/internal/common/health"
 	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/metrics"
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/templates"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
 	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
 	"github.com/jayanth-parthsarathy/notify/internal/common/util"
 	producer_types "github.com/jayanth-parthsarathy/notify/internal/producer/types"
 	amqp "github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func validateRequestBody(w http.ResponseWriter, req *http.Request) []byte {
+type Server struct {
+	Conns             *connection.Manager
+	Idempotency       producer_types.IdempotencyStore
+	IdempotencyWindow time.Duration
+	Status            status.Store
+	Templates         templates.Store
+	Schedule          schedule.Store
+	ConfirmTimeout    time.Duration
+	AllowedSenders    []string
+
+	Channels              *ChannelPool
+	ChannelPoolSize       int
+	ChannelAcquireTimeout time.Duration
+	ShutdownTimeout       time.Duration
+	Addr                  string
+	Health                *health.Checker
+	Auth                  *auth.Authenticator
+	ClientLimit           *ratelimit.Keyed
+	RecipientLimit        *ratelimit.Keyed
+	DomainLimit           *ratelimit.Keyed
+}
+
+func NewServer(conns *connection.Manager, idempotency producer_types.IdempotencyStore, idempotencyWindow time.Duration, statusStore status.Store, templateStore templates.Store, scheduleStore schedule.Store) *Server {
+	s := &Server{Conns: conns, Idempotency: idempotency, IdempotencyWindow: idempotencyWindow, Status: statusStore, Templates: templateStore, Schedule: scheduleStore, ConfirmTimeout: 5 * time.Second, ChannelPoolSize: 16, ChannelAcquireTimeout: time.Second, ShutdownTimeout: 25 * time.Second, Addr: ":8090", Health: health.NewChecker()}
+	s.Health.Add("rabbitmq", health.AMQP(conns))
+	s.Health.Add("channel", s.checkChannel)
+	return s
+}
+
+// checkChannel borrows a channel from the pool, so the producer reports not
+// ready while it could not serve a publish either.
+func (s *Server) checkChannel(ctx context.Context) error {
+	if s.Channels == nil {
+		return errors.New("channel pool is not started")
+	}
+	ch, err := s.Channels.Get(ctx)
+	if err != nil {
+		return err
+	}
+	return ch.Close()
+}
+
+func validateRequestBody(w http.ResponseWriter, req *http.Request, allowedFrom []string) (types.RequestBody, []byte) {
 	var reqBody types.RequestBody
-	err := json.NewDecoder(req.Body).Decode(&reqBody)
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, constants.MaxRequestBytes)).Decode(&reqBody)
 	if err != nil {
 		logs.LogError(err, "Invalid request body")
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return nil
+		writeDecodeError(w, err)
+		return reqBody, nil
 	}
 	defer req.Body.Close()
+	if err := reqBody.Validate(allowedFrom); err != nil {
+		http.Error(w, "Invalid notification: "+err.Error(), http.StatusBadRequest)
+		return reqBody, nil
+	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		logs.LogError(err, "Invalid JSON Structure")
 		http.Error(w, "Invalid JSON Structure", http.StatusInternalServerError)
-		return nil
+		return reqBody, nil
 	}
-	return jsonBody
+	return reqBody, jsonBody
 }
 
-func publishMessage(jsonBody []byte, ch producer_types.Channel, w http.ResponseWriter, ctx context.Context) error {
-	err := ch.PublishWithContext(ctx, "", constants.MainQueueName, false, false, amqp.Publishing{
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
+
+func publish(ctx context.Context, ch producer_types.Channel, jsonBody []byte, messageId string) (err error) {
+	spanCtx, span := tracing.Tracer().Start(ctx, "publish notification", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(tracing.MessageID(messageId)))
+	defer func(start time.Time) {
+		metrics.Since(metrics.PublishDuration, start, metrics.Result(err))
+		tracing.End(span, err)
+	}(time.Now())
+	return ch.PublishWithContext(ctx, "", constants.MainQueueName, true, false, amqp.Publishing{
 		DeliveryMode: amqp.Persistent,
 		ContentType:  "application/json",
 		Body:         jsonBody,
-		MessageId:    uuid.New().String(),
+		MessageId:    messageId,
+		Headers:      auth.Stamp(ctx, tracing.Inject(spanCtx, nil)),
 	})
+}
+
+func publishMessage(jsonBody []byte, messageId string, ch producer_types.Channel, w http.ResponseWriter, ctx context.Context) error {
+	err := publish(ctx, ch, jsonBody, messageId)
 	if err != nil {
 		logs.LogError(err, "Failed to publish message:")
-		http.Error(w, "could not queue notification", http.StatusInternalServerError)
+		code, message := publishErrorResponse(err)
+		http.Error(w, message, code)
 		return err
 	}
 	return nil
 }
 
-func writeSuccessResponse(w http.ResponseWriter, jsonBody []byte) {
-	log.Debugf("Published message: %s\n", string(jsonBody))
+func publishErrorResponse(err error) (int, string) {
+	switch {
+	case errors.Is(err, producer_types.ErrUnroutable):
+		return http.StatusBadGateway, "notification queue is unavailable"
+	case errors.Is(err, producer_types.ErrNacked):
+		return http.StatusServiceUnavailable, "broker rejected notification"
+	case errors.Is(err, producer_types.ErrConfirmTimeout):
+		return http.StatusGatewayTimeout, "timed out waiting for broker confirmation"
+	default:
+		return http.StatusInternalServerError, "could not queue notification"
+	}
+}
+
+func writeSuccessResponse(w http.ResponseWriter, messageId string) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Notification queued successfully"))
+	json.NewEncoder(w).Encode(producer_types.NewNotifyResponse(messageId))
 }
 
-func handleNotification(w http.ResponseWriter, req *http.Request, ch producer_types.Channel) {
-	defer ch.Close()
-	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+// idempotencyScope namespaces key by the API key that sent the request, so
+// clients can neither replay nor block each other's keys.
+func idempotencyScope(ctx context.Context, key string) string {
+	if k, ok := auth.FromContext(ctx); ok {
+		return k.ID + ":" + key
+	}
+	return key
+}
+
+func fingerprint(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) reserveIdempotencyKey(ctx context.Context, w http.ResponseWriter, key string, body []byte, messageId string) (string, bool) {
+	if s.Idempotency == nil || key == "" {
+		return messageId, true
+	}
+	if len(key) > constants.MaxIdempotencyKeyLength {
+		http.Error(w, fmt.Sprintf("Idempotency-Key must not exceed %d characters", constants.MaxIdempotencyKeyLength), http.StatusBadRequest)
+		return "", false
+	}
+	storedId, reserved, err := s.Idempotency.Reserve(ctx, idempotencyScope(ctx, key), fingerprint(body), messageId, s.IdempotencyWindow)
+	if errors.Is(err, producer_types.ErrIdempotencyKeyReused) {
+		http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+		return "", false
+	}
+	if err != nil {
+		logs.LogError(err, "Failed to reserve idempotency key")
+		http.Error(w, "could not queue notification", http.StatusInternalServerError)
+		return "", false
+	}
+	if !reserved {
+		log.Debugf("Idempotency key %s already used for message %s", key, storedId)
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeSuccessResponse(w, storedId)
+		return "", false
+	}
+	return messageId, true
+}
+
+func (s *Server) releaseIdempotencyKey(ctx context.Context, key string) {
+	if s.Idempotency == nil || key == "" {
+		return
+	}
+	err := s.Idempotency.Release(context.WithoutCancel(ctx), idempotencyScope(ctx, key))
+	logs.LogError(err, "Failed to release idempotency key")
+}
+
+// owner identifies the API key that authorized ctx. Notifications are only
+// visible to, and cancellable by, the key that sent them; with authentication
+// disabled every notification has the empty owner.
+func owner(ctx context.Context) string {
+	key, _ := auth.FromContext(ctx)
+	return key.ID
+}
+
+// allowedFrom lists the addresses a request may name as its from: the
+// configured AllowedSenders and the sender identity of its API key.
+func (s *Server) allowedFrom(ctx context.Context) []string {
+	allowed := slices.Clone(s.AllowedSenders)
+	if key, ok := auth.FromContext(ctx); ok && key.Sender != "" {
+		allowed = append(allowed, key.Sender)
+	}
+	return allowed
+}
+
+func (s *Server) trackStatus(ctx context.Context, messageId string, state string) {
+	if s.Status == nil {
+		return
+	}
+	err := s.Status.Track(ctx, messageId, owner(ctx), state)
+	logs.LogError(err, "Failed to set notification status")
+}
+
+func (s *Server) setStatus(ctx context.Context, messageId string, state string) {
+	if s.Status == nil {
+		return
+	}
+	err := s.Status.Set(ctx, messageId, state)
+	logs.LogError(err, "Failed to set notification status")
+}
+
+func (s *Server) deleteStatus(messageId string) {
+	if s.Status == nil {
+		return
+	}
+	err := s.Status.Delete(context.Background(), messageId)
+	logs.LogError(err, "Failed to delete notification status")
+}
+
+func (s *Server) handleNotification(w http.ResponseWriter, req *http.Request, acquire channelAcquirer) {
+	ctx, span := tracing.Tracer().Start(tracing.ExtractHTTP(req), "POST /notify", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	if req.Method != http.MethodPost {
 		log.Errorf("Method with post is accepted: %s", req.Method)
 		http.Error(w, "Only post method is accepted", http.StatusMethodNotAllowed)
 		return
 	}
-	jsonBody := validateRequestBody(w, req)
+	reqBody, jsonBody := validateRequestBody(w, req, s.allowedFrom(ctx))
 	if jsonBody == nil {
 		return
 	}
-	err := publishMessage(jsonBody, ch, w, ctx)
+	sendAt, delayed, err := s.scheduledAt(reqBody)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idempotencyKey := req.Header.Get("Idempotency-Key")
+	messageId, ok := s.reserveIdempotencyKey(ctx, w, idempotencyKey, jsonBody, uuid.New().String())
+	if !ok {
+		return
+	}
+	span.SetAttributes(tracing.MessageID(messageId))
+	if allowed, wait := s.allowRecipients(reqBody); !allowed {
+		s.releaseIdempotencyKey(ctx, idempotencyKey)
+		writeRateLimited(w, "recipient rate limit exceeded", wait)
+		return
+	}
+	if delayed {
+		err = s.scheduleNotification(ctx, reqBody, messageId, sendAt)
+		if err != nil {
+			logs.LogError(err, "Failed to schedule message")
+			http.Error(w, "could not schedule notification", http.StatusInternalServerError)
+			s.releaseIdempotencyKey(ctx, idempotencyKey)
+			return
+		}
+		log.Debugf("Scheduled message %s for %s", messageId, sendAt.Format(time.RFC3339))
+		writeScheduledResponse(w, messageId, sendAt)
 		return
 	}
-	writeSuccessResponse(w, jsonBody)
+	ch, ok := acquire(w, req)
+	if !ok {
+		s.releaseIdempotencyKey(ctx, idempotencyKey)
+		return
+	}
+	defer ch.Close()
+	s.trackStatus(ctx, messageId, status.Queued)
+	err = publishMessage(jsonBody, messageId, ch, w, ctx)
+	if err != nil {
+		s.deleteStatus(messageId)
+		s.releaseIdempotencyKey(ctx, idempotencyKey)
+		return
+	}
+	log.Debugf("Published message %s: %s", messageId, string(jsonBody))
+	writeSuccessResponse(w, messageId)
 }
 
-func StartServer(conn *amqp.Connection) {
-	http.HandleFunc("/notify", func(w http.ResponseWriter, req *http.Request) {
-		ch := util.CreateChannel(conn)
-		handleNotification(w, req, ch)
-	})
-	err := http.ListenAndServe(":8090", nil)
-	logs.FailOnError(err, "Server failed to start")
+func (s *Server) publishBatch(ctx context.Context, ch producer_types.Channel, items []types.RequestBody) producer_types.BatchResponse {
+	resp := producer_types.BatchResponse{Results: make([]producer_types.BatchResult, len(items))}
+	allowedFrom := s.allowedFrom(ctx)
+	for i, item := range items {
+		resp.Results[i].Index = i
+		if err := item.Validate(allowedFrom); err != nil {
+			resp.Results[i].Error = err.Error()
+			resp.Failed++
+			continue
+		}
+		sendAt, delayed, err := s.scheduledAt(item)
+		if err != nil {
+			resp.Results[i].Error = err.Error()
+			resp.Failed++
+			continue
+		}
+		if allowed, wait := s.allowRecipients(item); !allowed {
+			resp.Results[i].Error = (&ratelimit.LimitedError{RetryAfter: wait}).Error()
+			resp.Failed++
+			continue
+		}
+		messageId := uuid.New().String()
+		if delayed {
+			err = s.scheduleNotification(ctx, item, messageId, sendAt)
+			if err != nil {
+				logs.LogError(err, "Failed to schedule batch item")
+				resp.Results[i].Error = "could not schedule notification"
+				resp.Failed++
+				continue
+			}
+			resp.Results[i].MessageId = messageId
+			resp.Results[i].SendAt = &sendAt
+			resp.Scheduled++
+			continue
+		}
+		jsonBody, err := json.Marshal(item)
+		if err != nil {
+			resp.Results[i].Error = "invalid JSON structure"
+			resp.Failed++
+			continue
+		}
+		s.trackStatus(ctx, messageId, status.Queued)
+		err = publish(ctx, ch, jsonBody, messageId)
+		if err != nil {
+			logs.LogError(err, "Failed to publish batch item")
+			s.deleteStatus(messageId)
+			_, resp.Results[i].Error = publishErrorResponse(err)
+			resp.Failed++
+			continue
+		}
+		resp.Results[i].MessageId = messageId
+		resp.Queued++
+	}
+	return resp
+}
+
+func (s *Server) handleBatchNotification(w http.ResponseWriter, req *http.Request, acquire channelAcquirer) {
+	ctx, span := tracing.Tracer().Start(tracing.ExtractHTTP(req), "POST /notify/batch", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if req.Method != http.MethodPost {
+		log.Errorf("Method with post is accepted: %s", req.Method)
+		http.Error(w, "Only post method is accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	var items []types.RequestBody
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, constants.MaxBatchRequestBytes)).Decode(&items)
+	if err != nil {
+		logs.LogError(err, "Invalid request body")
+		writeDecodeError(w, err)
+		return
+	}
+	defer req.Body.Close()
+	if len(items) == 0 {
+		http.Error(w, "Batch must contain at least one notification", http.StatusBadRequest)
+		return
+	}
+	if len(items) > constants.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("Batch must not exceed %d notifications", constants.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	ch, ok := acquire(w, req)
+	if !ok {
+		return
+	}
+	defer ch.Close()
+	resp := s.publishBatch(ctx, ch, items)
+	log.Debugf("Published batch: %d queued, %d scheduled, %d failed", resp.Queued, resp.Scheduled, resp.Failed)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, req *http.Request) {
+	messageId := req.PathValue("id")
+	if s.Status == nil {
+		http.Error(w, "Status tracking is not enabled", http.StatusNotFound)
+		return
+	}
+	st, err := s.Status.Get(req.Context(), messageId)
+	if err == nil && st.Owner != owner(req.Context()) {
+		err = status.ErrNotFound
+	}
+	if errors.Is(err, status.ErrNotFound) {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logs.LogError(err, "Failed to fetch notification status")
+		http.Error(w, "could not fetch notification status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// channelAcquirer borrows a publishing channel for a request, writing the
+// error response itself when none is available. Handlers call it only once
+// the request is ready to publish, so invalid requests never hold a channel.
+type channelAcquirer func(http.ResponseWriter, *http.Request) (producer_types.Channel, bool)
+
+func (s *Server) acquireChannel(w http.ResponseWriter, req *http.Request) (producer_types.Channel, bool) {
+	ch, err := s.Channels.Get(req.Context())
+	if err != nil {
+		logs.LogError(err, "Failed to acquire channel")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "producer is busy, retry later", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return ch, true
+}
+
+func (s *Server) Start(ctx context.Context) {
+	if s.Channels == nil {
+		s.Channels = NewChannelPool(s.ChannelPoolSize, s.ChannelAcquireTimeout, OpenConfirmChannel(s.Conns, s.ConfirmTimeout))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/notify", metrics.InstrumentHandler("notify", s.Auth.RequireFunc(auth.ScopeNotifySend, s.limitClient(func(w http.ResponseWriter, req *http.Request) {
+		s.handleNotification(w, req, s.acquireChannel)
+	}))))
+	mux.Handle("/notify/batch", metrics.InstrumentHandler("notify_batch", s.Auth.RequireFunc(auth.ScopeNotifySend, s.limitClient(func(w http.ResponseWriter, req *http.Request) {
+		s.handleBatchNotification(w, req, s.acquireChannel)
+	}))))
+	mux.Handle("GET /metrics", metrics.Handler())
+	s.Health.Register(mux)
+	mux.Handle("GET /notifications/{id}", s.Auth.RequireFunc(auth.ScopeNotifySend, s.handleStatus))
+	if s.Schedule != nil {
+		mux.Handle("DELETE /notifications/{id}", s.Auth.RequireFunc(auth.ScopeNotifySend, s.handleCancel))
+	}
+	if s.Templates != nil {
+		s.registerTemplateRoutes(mux)
+	}
+	err := util.Serve(ctx, &http.Server{Addr: s.Addr, Handler: mux}, s.ShutdownTimeout)
+	if ctx.Err() == nil {
+		logs.FailOnError(err, "Server failed to start")
+	}
+	logs.LogError(err, "Server did not shut down cleanly")
+	s.Channels.Close()
 }
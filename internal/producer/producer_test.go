@@ -3,18 +3,35 @@ package producer
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v4"
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
 	"github.com/jayanth-parthsarathy/notify/internal/common/constants"
+	"github.com/jayanth-parthsarathy/notify/internal/common/ratelimit"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status/statustest"
+	"github.com/jayanth-parthsarathy/notify/internal/common/templates"
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+	producer_types "github.com/jayanth-parthsarathy/notify/internal/producer/types"
+	"github.com/pashagolub/pgxmock"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func TestValidateRequestBody_ValidJSON(t *testing.T) {
@@ -22,7 +39,7 @@ func TestValidateRequestBody_ValidJSON(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewBufferString(validJSON))
 	w := httptest.NewRecorder()
 
-	body := validateRequestBody(w, req)
+	_, body := validateRequestBody(w, req, nil)
 	require.NotNil(t, body)
 	require.JSONEq(t, validJSON, string(body))
 	require.Equal(t, http.StatusOK, w.Code)
@@ -33,7 +50,7 @@ func TestValidateRequestBody_InvalidJSON(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewBufferString(invalidJSON))
 	w := httptest.NewRecorder()
 
-	body := validateRequestBody(w, req)
+	_, body := validateRequestBody(w, req, nil)
 	require.Nil(t, body)
 	require.Equal(t, http.StatusBadRequest, w.Code)
 	require.Contains(t, w.Body.String(), "Invalid request body")
@@ -41,17 +58,17 @@ func TestValidateRequestBody_InvalidJSON(t *testing.T) {
 
 func TestWriteSuccessResponse(t *testing.T) {
 	rr := httptest.NewRecorder()
-	jsonBody := []byte(`{"foo":"bar"}`)
 
-	writeSuccessResponse(rr, jsonBody)
+	writeSuccessResponse(rr, "msg-1")
 
 	if got, want := rr.Code, http.StatusOK; got != want {
 		t.Errorf("Status = %d; want %d", got, want)
 	}
 
-	if got, want := strings.TrimSpace(rr.Body.String()), "Notification queued successfully"; got != want {
-		t.Errorf("Body = %q; want %q", got, want)
-	}
+	var resp producer_types.NotifyResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "msg-1", resp.MessageId)
+	assert.Equal(t, "Notification queued successfully", resp.Message)
 }
 
 type MockChannel struct {
@@ -67,33 +84,102 @@ func (m *MockChannel) Close() error {
 	return m.Called().Error(0)
 }
 
+func channelOf(ch producer_types.Channel) channelAcquirer {
+	return func(http.ResponseWriter, *http.Request) (producer_types.Channel, bool) {
+		return ch, true
+	}
+}
+
 func TestPublishMessage_Success(t *testing.T) {
 	mockCh := new(MockChannel)
 	ctx := context.Background()
 	body := []byte(`{"msg":"hello"}`)
 	recorder := httptest.NewRecorder()
 
-	mockCh.On("PublishWithContext", ctx, "", constants.MainQueueName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
-		return p.ContentType == "application/json" && bytes.Equal(p.Body, body)
+	mockCh.On("PublishWithContext", ctx, "", constants.MainQueueName, true, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return p.ContentType == "application/json" && bytes.Equal(p.Body, body) && p.MessageId == "msg-1"
 	})).Return(nil)
 
-	err := publishMessage(body, mockCh, recorder, ctx)
+	err := publishMessage(body, "msg-1", mockCh, recorder, ctx)
 
 	assert.NoError(t, err)
 	mockCh.AssertExpectations(t)
 }
 
+func TestPublishMessage_InjectsTraceContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "POST /notify")
+	defer span.End()
+	traceId := span.SpanContext().TraceID().String()
+	mockCh := new(MockChannel)
+
+	mockCh.On("PublishWithContext", ctx, "", constants.MainQueueName, true, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		traceparent, _ := p.Headers["traceparent"].(string)
+		return strings.Contains(traceparent, traceId)
+	})).Return(nil)
+
+	err := publishMessage([]byte(`{"msg":"hello"}`), "msg-1", mockCh, httptest.NewRecorder(), ctx)
+
+	assert.NoError(t, err)
+	mockCh.AssertExpectations(t)
+}
+
+func TestPublishMessage_StampsSender(t *testing.T) {
+	ctx := auth.WithKey(context.Background(), auth.Key{ID: "key-1", Sender: "billing@example.com"})
+	mockCh := new(MockChannel)
+
+	mockCh.On("PublishWithContext", ctx, "", constants.MainQueueName, true, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+		return p.Headers[auth.SenderHeader] == "billing@example.com"
+	})).Return(nil)
+
+	err := publishMessage([]byte(`{"msg":"hello"}`), "msg-1", mockCh, httptest.NewRecorder(), ctx)
+
+	assert.NoError(t, err)
+	mockCh.AssertExpectations(t)
+}
+
+func TestHandleNotification_RestrictsFrom(t *testing.T) {
+	cases := []struct {
+		name     string
+		from     string
+		wantCode int
+	}{
+		{"key sender", "Billing <Billing@example.com>", http.StatusOK},
+		{"allowlisted", "noreply@example.com", http.StatusOK},
+		{"other address", "ceo@example.com", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{AllowedSenders: []string{"noreply@example.com"}}
+			mockCh := new(MockChannel)
+			mockCh.On("Close").Return(nil)
+			mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).Return(nil)
+
+			body := fmt.Sprintf(`{"email":"a@b.com","from":%q,"message":"ok"}`, tc.from)
+			req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(body))
+			req = req.WithContext(auth.WithKey(req.Context(), auth.Key{ID: "key-1", Sender: "billing@example.com"}))
+			rr := httptest.NewRecorder()
+			server.handleNotification(rr, req, channelOf(mockCh))
+
+			assert.Equal(t, tc.wantCode, rr.Code)
+			if tc.wantCode == http.StatusBadRequest {
+				assert.Contains(t, rr.Body.String(), "from: is not an allowed sender")
+			}
+		})
+	}
+}
+
 func TestPublishMessage_Failure(t *testing.T) {
 	mockCh := new(MockChannel)
 	ctx := context.Background()
 	body := []byte(`{"msg":"fail"}`)
 	recorder := httptest.NewRecorder()
 
-	mockCh.On("PublishWithContext", ctx, "", constants.MainQueueName, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+	mockCh.On("PublishWithContext", ctx, "", constants.MainQueueName, true, false, mock.MatchedBy(func(p amqp.Publishing) bool {
 		return p.ContentType == "application/json" && bytes.Equal(p.Body, body)
 	})).Return(assert.AnError)
 
-	err := publishMessage(body, mockCh, recorder, ctx)
+	err := publishMessage(body, "msg-1", mockCh, recorder, ctx)
 
 	assert.Error(t, err)
 	assert.Equal(t, http.StatusInternalServerError, recorder.Result().StatusCode)
@@ -102,6 +188,9 @@ func TestPublishMessage_Failure(t *testing.T) {
 
 func TestHandleNotification(t *testing.T) {
 	const uri = "/notify"
+	oversizedAttachment := fmt.Sprintf(`{"email":"a@b.com","message":"m","attachments":[{"filename":"big.bin","content":%q}]}`,
+		base64.StdEncoding.EncodeToString(make([]byte, types.MaxAttachmentBytes+1)))
+	oversizedBody := `{"email":"a@b.com","message":"` + strings.Repeat("m", constants.MaxRequestBytes) + `"}`
 
 	type testCase struct {
 		name           string
@@ -127,6 +216,41 @@ func TestHandleNotification(t *testing.T) {
 			wantCode:       http.StatusBadRequest,
 			wantBodySubstr: "Invalid request body",
 		},
+		{
+			name:           "invalid notification gives 400",
+			method:         http.MethodPost,
+			body:           `{"email":"a@b.com","message":"m","cc":["not an address"]}`,
+			wantCode:       http.StatusBadRequest,
+			wantBodySubstr: "cc[0]: is not a valid address",
+		},
+		{
+			name:           "every invalid address is reported",
+			method:         http.MethodPost,
+			body:           `{"to":["a@b.com","bad"],"message":"m","cc":["worse"],"reply_to":"nope"}`,
+			wantCode:       http.StatusBadRequest,
+			wantBodySubstr: "to[1]: is not a valid address; cc[0]: is not a valid address; reply_to: is not a valid address",
+		},
+		{
+			name:           "send_valid still rejects an invalid reply_to",
+			method:         http.MethodPost,
+			body:           `{"to":["a@b.com","bad"],"message":"m","reply_to":"nope","on_invalid_recipients":"send_valid"}`,
+			wantCode:       http.StatusBadRequest,
+			wantBodySubstr: "reply_to: is not a valid address",
+		},
+		{
+			name:           "oversized attachment gives 400",
+			method:         http.MethodPost,
+			body:           oversizedAttachment,
+			wantCode:       http.StatusBadRequest,
+			wantBodySubstr: "attachments: must not exceed",
+		},
+		{
+			name:           "oversized body gives 413",
+			method:         http.MethodPost,
+			body:           oversizedBody,
+			wantCode:       http.StatusRequestEntityTooLarge,
+			wantBodySubstr: "Request body must not exceed",
+		},
 		{
 			name:   "publish error gives 500",
 			method: http.MethodPost,
@@ -134,12 +258,51 @@ func TestHandleNotification(t *testing.T) {
 			setupMock: func(m *MockChannel) {
 				m.On("Close").Return(nil)
 				m.On("PublishWithContext",
-					mock.Anything, "", constants.MainQueueName, false, false, mock.Anything,
+					mock.Anything, "", constants.MainQueueName, true, false, mock.Anything,
 				).Return(errors.New("boom"))
 			},
 			wantCode:       http.StatusInternalServerError,
 			wantBodySubstr: "could not queue notification",
 		},
+		{
+			name:   "unroutable message gives 502",
+			method: http.MethodPost,
+			body:   `{"email":"x@x","message":"y"}`,
+			setupMock: func(m *MockChannel) {
+				m.On("Close").Return(nil)
+				m.On("PublishWithContext",
+					mock.Anything, "", constants.MainQueueName, true, false, mock.Anything,
+				).Return(producer_types.ErrUnroutable)
+			},
+			wantCode:       http.StatusBadGateway,
+			wantBodySubstr: "notification queue is unavailable",
+		},
+		{
+			name:   "broker nack gives 503",
+			method: http.MethodPost,
+			body:   `{"email":"x@x","message":"y"}`,
+			setupMock: func(m *MockChannel) {
+				m.On("Close").Return(nil)
+				m.On("PublishWithContext",
+					mock.Anything, "", constants.MainQueueName, true, false, mock.Anything,
+				).Return(producer_types.ErrNacked)
+			},
+			wantCode:       http.StatusServiceUnavailable,
+			wantBodySubstr: "broker rejected notification",
+		},
+		{
+			name:   "confirm timeout gives 504",
+			method: http.MethodPost,
+			body:   `{"email":"x@x","message":"y"}`,
+			setupMock: func(m *MockChannel) {
+				m.On("Close").Return(nil)
+				m.On("PublishWithContext",
+					mock.Anything, "", constants.MainQueueName, true, false, mock.Anything,
+				).Return(producer_types.ErrConfirmTimeout)
+			},
+			wantCode:       http.StatusGatewayTimeout,
+			wantBodySubstr: "timed out waiting for broker confirmation",
+		},
 		{
 			name:   "happy path gives 200",
 			method: http.MethodPost,
@@ -148,7 +311,7 @@ func TestHandleNotification(t *testing.T) {
 				m.On("Close").Return(nil)
 				m.On("PublishWithContext",
 					mock.Anything,
-					"", constants.MainQueueName, false, false,
+					"", constants.MainQueueName, true, false,
 					mock.MatchedBy(func(p amqp.Publishing) bool {
 						return p.ContentType == "application/json"
 					}),
@@ -165,14 +328,13 @@ func TestHandleNotification(t *testing.T) {
 
 			if tc.setupMock != nil {
 				tc.setupMock(mockCh)
-			} else {
-				mockCh.On("Close").Return(nil)
 			}
 
 			req := httptest.NewRequest(tc.method, uri, strings.NewReader(tc.body))
 			rr := httptest.NewRecorder()
 
-			handleNotification(rr, req, mockCh)
+			server := &Server{}
+			server.handleNotification(rr, req, channelOf(mockCh))
 			res := rr.Result()
 			defer res.Body.Close()
 
@@ -184,3 +346,734 @@ func TestHandleNotification(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleBatchNotification_MixedResults(t *testing.T) {
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	mockCh.On("PublishWithContext",
+		mock.Anything, "", constants.MainQueueName, true, false,
+		mock.MatchedBy(func(p amqp.Publishing) bool {
+			return bytes.Contains(p.Body, []byte("good@example.com"))
+		}),
+	).Return(nil)
+	mockCh.On("PublishWithContext",
+		mock.Anything, "", constants.MainQueueName, true, false,
+		mock.MatchedBy(func(p amqp.Publishing) bool {
+			return bytes.Contains(p.Body, []byte("down@example.com"))
+		}),
+	).Return(errors.New("boom"))
+
+	body := `[
+		{"email":"good@example.com","message":"hi","subject":"s"},
+		{"email":"not-an-email","message":"hi"},
+		{"email":"down@example.com","message":"hi"}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "/notify/batch", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	server := &Server{}
+	server.handleBatchNotification(rr, req, channelOf(mockCh))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp producer_types.BatchResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Queued)
+	assert.Equal(t, 2, resp.Failed)
+	require.Len(t, resp.Results, 3)
+	assert.NotEmpty(t, resp.Results[0].MessageId)
+	assert.Empty(t, resp.Results[0].Error)
+	assert.Contains(t, resp.Results[1].Error, "email")
+	assert.Empty(t, resp.Results[1].MessageId)
+	assert.Equal(t, "could not queue notification", resp.Results[2].Error)
+	mockCh.AssertNumberOfCalls(t, "PublishWithContext", 2)
+	mockCh.AssertNumberOfCalls(t, "Close", 1)
+}
+
+func TestHandleBatchNotification_Rejects(t *testing.T) {
+	tooLarge := "[" + strings.Repeat(`{"email":"a@b.com","message":"m"},`, constants.MaxBatchSize) + `{"email":"a@b.com","message":"m"}]`
+	cases := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+	}{
+		{"non-POST gives 405", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"invalid JSON gives 400", http.MethodPost, `{"email":"a@b.com"}`, http.StatusBadRequest},
+		{"empty batch gives 400", http.MethodPost, `[]`, http.StatusBadRequest},
+		{"oversized batch gives 413", http.MethodPost, tooLarge, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/notify/batch", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+
+			server := &Server{}
+			server.handleBatchNotification(rr, req, noChannel(t))
+
+			assert.Equal(t, tc.wantCode, rr.Code)
+		})
+	}
+}
+
+func noChannel(t *testing.T) channelAcquirer {
+	return func(http.ResponseWriter, *http.Request) (producer_types.Channel, bool) {
+		t.Error("a channel was borrowed for a request that is never published")
+		return nil, false
+	}
+}
+
+func TestHandleNotification_InvalidRequestDoesNotBorrowChannel(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"email":"test","message":"m"}`))
+	rr := httptest.NewRecorder()
+
+	server := &Server{}
+	server.handleNotification(rr, req, noChannel(t))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHandleNotification_IdempotencyKeyReplaysOriginalMessageId(t *testing.T) {
+	server := &Server{Idempotency: NewMemoryIdempotencyStore(), IdempotencyWindow: time.Minute}
+	body := `{"email":"a@b.com","message":"ok"}`
+
+	var firstId string
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).
+		Run(func(args mock.Arguments) {
+			firstId = args.Get(5).(amqp.Publishing).MessageId
+		}).Return(nil).Once()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "order-42")
+		rr := httptest.NewRecorder()
+
+		server.handleNotification(rr, req, channelOf(mockCh))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp producer_types.NotifyResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, firstId, resp.MessageId)
+		if i == 1 {
+			assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+		}
+	}
+	mockCh.AssertNumberOfCalls(t, "PublishWithContext", 1)
+}
+
+func TestHandleNotification_IdempotencyKeyScopedAndBoundToBody(t *testing.T) {
+	server := &Server{Idempotency: NewMemoryIdempotencyStore(), IdempotencyWindow: time.Minute}
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).Return(nil)
+
+	send := func(keyID string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(body))
+		req = req.WithContext(auth.WithKey(req.Context(), auth.Key{ID: keyID}))
+		req.Header.Set("Idempotency-Key", "order-44")
+		rr := httptest.NewRecorder()
+		server.handleNotification(rr, req, channelOf(mockCh))
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, send("key-a", `{"email":"a@b.com","message":"ok"}`).Code)
+	rr := send("key-b", `{"email":"a@b.com","message":"ok"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Idempotent-Replayed"), "another client's key is not replayed")
+	assert.Equal(t, http.StatusUnprocessableEntity, send("key-a", `{"email":"a@b.com","message":"changed"}`).Code)
+	mockCh.AssertNumberOfCalls(t, "PublishWithContext", 2)
+}
+
+func TestHandleNotification_IdempotencyKeyReleasedOnPublishFailure(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	server := &Server{Idempotency: store, IdempotencyWindow: time.Minute}
+
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).
+		Return(errors.New("boom"))
+
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"email":"a@b.com","message":"ok"}`))
+	req.Header.Set("Idempotency-Key", "order-43")
+	rr := httptest.NewRecorder()
+	server.handleNotification(rr, req, channelOf(mockCh))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	_, reserved, err := store.Reserve(context.Background(), "order-43", "", "next", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+}
+
+func TestMemoryIdempotencyStore_WindowExpiry(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryIdempotencyStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	id, reserved, err := store.Reserve(ctx, "k", "fp", "first", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Equal(t, "first", id)
+
+	id, reserved, err = store.Reserve(ctx, "k", "fp", "second", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, "first", id)
+
+	now = now.Add(2 * time.Minute)
+	id, reserved, err = store.Reserve(ctx, "k", "fp", "third", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Equal(t, "third", id)
+}
+
+func TestPgIdempotencyStore_Reserve(t *testing.T) {
+	mockDB, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	defer mockDB.Close(context.Background())
+	store := NewPgIdempotencyStore(mockDB)
+
+	mockDB.ExpectExec(`DELETE FROM idempotency_keys WHERE expires_at <= now\(\)`).
+		WillReturnResult(pgxmock.NewResult("DELETE", 3))
+	for _, fp := range []string{"fp", "other"} {
+		mockDB.ExpectQuery(`INSERT INTO idempotency_keys`).
+			WithArgs("k", "new-id", fp, float64(60)).
+			WillReturnError(pgx.ErrNoRows)
+		mockDB.ExpectQuery(`SELECT message_id, fingerprint FROM idempotency_keys WHERE key = \$1`).
+			WithArgs("k").
+			WillReturnRows(pgxmock.NewRows([]string{"message_id", "fingerprint"}).AddRow("old-id", "fp"))
+	}
+
+	id, reserved, err := store.Reserve(context.Background(), "k", "fp", "new-id", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, "old-id", id)
+
+	_, _, err = store.Reserve(context.Background(), "k", "other", "new-id", time.Minute)
+	assert.ErrorIs(t, err, producer_types.ErrIdempotencyKeyReused)
+	assert.NoError(t, mockDB.ExpectationsWereMet(), "expired keys are purged at most once a minute")
+}
+
+func TestHandleNotification_RecordsQueuedStatus(t *testing.T) {
+	store := statustest.NewMemoryStore()
+	server := &Server{Status: store}
+
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"email":"a@b.com","message":"ok"}`))
+	rr := httptest.NewRecorder()
+	server.handleNotification(rr, req, channelOf(mockCh))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp producer_types.NotifyResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	st, err := store.Get(context.Background(), resp.MessageId)
+	require.NoError(t, err)
+	assert.Equal(t, status.Queued, st.Status)
+}
+
+func TestHandleStatus(t *testing.T) {
+	store := statustest.NewMemoryStore()
+	require.NoError(t, store.Set(context.Background(), "msg-1", status.Delivered))
+	server := &Server{Status: store}
+
+	req := httptest.NewRequest(http.MethodGet, "/notifications/msg-1", nil)
+	req.SetPathValue("id", "msg-1")
+	rr := httptest.NewRecorder()
+	server.handleStatus(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var st status.Status
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &st))
+	assert.Equal(t, "msg-1", st.MessageId)
+	assert.Equal(t, status.Delivered, st.Status)
+
+	req = httptest.NewRequest(http.MethodGet, "/notifications/missing", nil)
+	req.SetPathValue("id", "missing")
+	rr = httptest.NewRecorder()
+	server.handleStatus(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHandleStatus_HidesOtherKeysNotifications(t *testing.T) {
+	store := statustest.NewMemoryStore()
+	require.NoError(t, store.Track(context.Background(), "msg-1", "key-a", status.Queued))
+	server := &Server{Status: store}
+
+	get := func(keyID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/notifications/msg-1", nil)
+		req = req.WithContext(auth.WithKey(req.Context(), auth.Key{ID: keyID}))
+		req.SetPathValue("id", "msg-1")
+		rr := httptest.NewRecorder()
+		server.handleStatus(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("key-a"))
+	assert.Equal(t, http.StatusNotFound, get("key-b"))
+}
+
+type MockTemplateStore struct {
+	mock.Mock
+}
+
+func (m *MockTemplateStore) Create(ctx context.Context, t templates.Template) (templates.Template, error) {
+	args := m.Called(ctx, t)
+	return args.Get(0).(templates.Template), args.Error(1)
+}
+
+func (m *MockTemplateStore) Update(ctx context.Context, t templates.Template) (templates.Template, error) {
+	args := m.Called(ctx, t)
+	return args.Get(0).(templates.Template), args.Error(1)
+}
+
+func (m *MockTemplateStore) Get(ctx context.Context, id string, version int) (templates.Template, error) {
+	args := m.Called(ctx, id, version)
+	return args.Get(0).(templates.Template), args.Error(1)
+}
+
+func (m *MockTemplateStore) List(ctx context.Context) ([]templates.Template, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]templates.Template), args.Error(1)
+}
+
+func (m *MockTemplateStore) Versions(ctx context.Context, id string) ([]templates.Template, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).([]templates.Template), args.Error(1)
+}
+
+func (m *MockTemplateStore) Delete(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func TestTemplateRoutes(t *testing.T) {
+	welcome := templates.Template{ID: "welcome", Version: 2, Subject: "Hi {{.name}}", Body: "Hello {{.name}}", Format: templates.FormatText}
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		setupMock      func(m *MockTemplateStore)
+		wantCode       int
+		wantBodySubstr string
+	}{
+		{
+			name:   "create gives 201",
+			method: http.MethodPost,
+			target: "/templates",
+			body:   `{"id":"welcome","subject":"Hi {{.name}}","body":"Hello {{.name}}"}`,
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Create", mock.Anything, templates.Template{ID: "welcome", Subject: "Hi {{.name}}", Body: "Hello {{.name}}", Format: templates.FormatText}).
+					Return(templates.Template{ID: "welcome", Version: 1, Format: templates.FormatText}, nil)
+			},
+			wantCode:       http.StatusCreated,
+			wantBodySubstr: `"version":1`,
+		},
+		{
+			name:           "create with broken template gives 400",
+			method:         http.MethodPost,
+			target:         "/templates",
+			body:           `{"id":"welcome","body":"Hello {{.name"}`,
+			wantCode:       http.StatusBadRequest,
+			wantBodySubstr: "Invalid template",
+		},
+		{
+			name:   "create existing gives 409",
+			method: http.MethodPost,
+			target: "/templates",
+			body:   `{"id":"welcome","body":"Hello"}`,
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Create", mock.Anything, mock.Anything).Return(templates.Template{}, templates.ErrExists)
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:   "update of unknown template gives 404",
+			method: http.MethodPut,
+			target: "/templates/unknown",
+			body:   `{"id":"unknown","body":"Hello"}`,
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Update", mock.Anything, mock.Anything).Return(templates.Template{}, templates.ErrNotFound)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:   "concurrent update gives 409",
+			method: http.MethodPut,
+			target: "/templates/welcome",
+			body:   `{"id":"welcome","body":"Hello"}`,
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Update", mock.Anything, mock.Anything).Return(templates.Template{}, templates.ErrConflict)
+			},
+			wantCode:       http.StatusConflict,
+			wantBodySubstr: "updated concurrently",
+		},
+		{
+			name:     "update with mismatched id gives 400",
+			method:   http.MethodPut,
+			target:   "/templates/welcome",
+			body:     `{"id":"other","body":"Hello"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "get specific version",
+			method: http.MethodGet,
+			target: "/templates/welcome?version=2",
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Get", mock.Anything, "welcome", 2).Return(welcome, nil)
+			},
+			wantCode:       http.StatusOK,
+			wantBodySubstr: `"version":2`,
+		},
+		{
+			name:   "delete gives 204",
+			method: http.MethodDelete,
+			target: "/templates/welcome",
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Delete", mock.Anything, "welcome").Return(nil)
+			},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:   "preview renders without sending",
+			method: http.MethodPost,
+			target: "/templates/welcome/preview",
+			body:   `{"data":{"name":"Ada"}}`,
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Get", mock.Anything, "welcome", 0).Return(welcome, nil)
+			},
+			wantCode:       http.StatusOK,
+			wantBodySubstr: `"body":"Hello Ada"`,
+		},
+		{
+			name:   "preview with missing data gives 422",
+			method: http.MethodPost,
+			target: "/templates/welcome/preview",
+			body:   `{"data":{}}`,
+			setupMock: func(m *MockTemplateStore) {
+				m.On("Get", mock.Anything, "welcome", 0).Return(welcome, nil)
+			},
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := new(MockTemplateStore)
+			if tc.setupMock != nil {
+				tc.setupMock(store)
+			}
+			server := &Server{Templates: store}
+			mux := http.NewServeMux()
+			server.registerTemplateRoutes(mux)
+
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.wantCode, rr.Code)
+			assert.Contains(t, rr.Body.String(), tc.wantBodySubstr)
+			store.AssertExpectations(t)
+		})
+	}
+}
+
+type MockScheduleStore struct {
+	mock.Mock
+}
+
+func (m *MockScheduleStore) Add(ctx context.Context, n schedule.Notification) error {
+	return m.Called(ctx, n).Error(0)
+}
+
+func (m *MockScheduleStore) Cancel(ctx context.Context, messageId string, owner string) error {
+	return m.Called(ctx, messageId, owner).Error(0)
+}
+
+func (m *MockScheduleStore) ReleaseDue(ctx context.Context, now time.Time, limit int, publish func(schedule.Notification) error) (int, error) {
+	args := m.Called(ctx, now, limit, publish)
+	return args.Int(0), args.Error(1)
+}
+
+func TestHandleNotification_SchedulesDelayedNotification(t *testing.T) {
+	statusStore := statustest.NewMemoryStore()
+	scheduleStore := new(MockScheduleStore)
+	server := &Server{Status: statusStore, Schedule: scheduleStore}
+
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	scheduleStore.On("Add", mock.Anything, mock.MatchedBy(func(n schedule.Notification) bool {
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(n.Body, &body))
+		_, hasDelay := body["delay"]
+		return !hasDelay && body["email"] == "a@b.com" && time.Until(n.SendAt) > 55*time.Minute &&
+			n.Owner == "key-a" && n.Headers[auth.SenderHeader] == "billing@example.com"
+	})).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"email":"a@b.com","message":"ok","delay":"1h"}`))
+	req = req.WithContext(auth.WithKey(req.Context(), auth.Key{ID: "key-a", Sender: "billing@example.com"}))
+	rr := httptest.NewRecorder()
+	server.handleNotification(rr, req, channelOf(mockCh))
+
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	var resp producer_types.NotifyResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.NotNil(t, resp.SendAt)
+	st, err := statusStore.Get(context.Background(), resp.MessageId)
+	require.NoError(t, err)
+	assert.Equal(t, status.Scheduled, st.Status)
+	assert.Equal(t, "key-a", st.Owner)
+	scheduleStore.AssertExpectations(t)
+	mockCh.AssertNotCalled(t, "PublishWithContext", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleNotification_ScheduledKeepsTraceContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	scheduleStore := new(MockScheduleStore)
+	server := &Server{Schedule: scheduleStore}
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	scheduleStore.On("Add", mock.Anything, mock.MatchedBy(func(n schedule.Notification) bool {
+		traceparent, _ := n.Headers["traceparent"].(string)
+		return strings.Contains(traceparent, traceId)
+	})).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"email":"a@b.com","message":"ok","delay":"1h"}`))
+	req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	server.handleNotification(rr, req, channelOf(mockCh))
+
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	scheduleStore.AssertExpectations(t)
+}
+
+func TestHandleNotification_SchedulingValidation(t *testing.T) {
+	cases := []struct {
+		name     string
+		server   *Server
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"send_at and delay", &Server{Schedule: new(MockScheduleStore)}, `{"email":"a@b.com","message":"ok","delay":"1h","send_at":"2099-01-01T00:00:00Z"}`, http.StatusBadRequest, "cannot be combined with delay"},
+		{"invalid delay", &Server{Schedule: new(MockScheduleStore)}, `{"email":"a@b.com","message":"ok","delay":"soon"}`, http.StatusBadRequest, "delay: is not a valid duration"},
+		{"scheduling disabled", &Server{}, `{"email":"a@b.com","message":"ok","send_at":"2099-01-01T00:00:00Z"}`, http.StatusBadRequest, "scheduled delivery is not enabled"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCh := new(MockChannel)
+			mockCh.On("Close").Return(nil)
+			req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			tc.server.handleNotification(rr, req, channelOf(mockCh))
+
+			assert.Equal(t, tc.wantCode, rr.Code)
+			assert.Contains(t, rr.Body.String(), tc.wantBody)
+		})
+	}
+}
+
+func TestHandleNotification_PastSendAtPublishesImmediately(t *testing.T) {
+	server := &Server{Schedule: new(MockScheduleStore)}
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"email":"a@b.com","message":"ok","send_at":"2000-01-01T00:00:00Z"}`))
+	rr := httptest.NewRecorder()
+	server.handleNotification(rr, req, channelOf(mockCh))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockCh.AssertExpectations(t)
+}
+
+func TestHandleCancel(t *testing.T) {
+	statusStore := statustest.NewMemoryStore()
+	scheduleStore := new(MockScheduleStore)
+	scheduleStore.On("Cancel", mock.Anything, "msg-1", "key-a").Return(nil)
+	scheduleStore.On("Cancel", mock.Anything, "sent", "key-a").Return(schedule.ErrNotFound)
+	server := &Server{Status: statusStore, Schedule: scheduleStore}
+
+	req := httptest.NewRequest(http.MethodDelete, "/notifications/msg-1", nil)
+	req = req.WithContext(auth.WithKey(req.Context(), auth.Key{ID: "key-a"}))
+	req.SetPathValue("id", "msg-1")
+	rr := httptest.NewRecorder()
+	server.handleCancel(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	st, err := statusStore.Get(context.Background(), "msg-1")
+	require.NoError(t, err)
+	assert.Equal(t, status.Cancelled, st.Status)
+
+	req = httptest.NewRequest(http.MethodDelete, "/notifications/sent", nil)
+	req = req.WithContext(auth.WithKey(req.Context(), auth.Key{ID: "key-a"}))
+	req.SetPathValue("id", "sent")
+	rr = httptest.NewRecorder()
+	server.handleCancel(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+type fakePooledChannel struct {
+	MockChannel
+	closed bool
+}
+
+func (f *fakePooledChannel) IsClosed() bool {
+	return f.closed
+}
+
+func TestChannelPool_ReusesAndBounds(t *testing.T) {
+	var opened []*fakePooledChannel
+	pool := NewChannelPool(2, 20*time.Millisecond, func() (producer_types.PooledChannel, error) {
+		ch := &fakePooledChannel{}
+		opened = append(opened, ch)
+		return ch, nil
+	})
+	ctx := context.Background()
+
+	first, err := pool.Get(ctx)
+	require.NoError(t, err)
+	second, err := pool.Get(ctx)
+	require.NoError(t, err)
+	_, err = pool.Get(ctx)
+	assert.ErrorIs(t, err, producer_types.ErrPoolExhausted)
+
+	require.NoError(t, first.Close())
+	require.NoError(t, first.Close())
+	third, err := pool.Get(ctx)
+	require.NoError(t, err)
+	assert.Len(t, opened, 2)
+	assert.Same(t, opened[0], third.(*pooledChannel).PooledChannel)
+
+	opened[1].closed = true
+	require.NoError(t, second.Close())
+	_, err = pool.Get(ctx)
+	require.NoError(t, err)
+	assert.Len(t, opened, 3)
+}
+
+func TestChannelPool_DiscardsChannelsClosedWhileIdle(t *testing.T) {
+	var opened []*fakePooledChannel
+	pool := NewChannelPool(1, 20*time.Millisecond, func() (producer_types.PooledChannel, error) {
+		ch := &fakePooledChannel{}
+		opened = append(opened, ch)
+		return ch, nil
+	})
+
+	ch, err := pool.Get(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, ch.Close())
+	opened[0].closed = true
+
+	ch, err = pool.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, opened[1], ch.(*pooledChannel).PooledChannel)
+}
+
+func TestAcquireChannel_PoolErrorsGive503(t *testing.T) {
+	cases := []struct {
+		name string
+		open func() (producer_types.PooledChannel, error)
+	}{
+		{"exhausted", func() (producer_types.PooledChannel, error) { return &fakePooledChannel{}, nil }},
+		{"broker refuses channel", func() (producer_types.PooledChannel, error) { return nil, amqp.ErrClosed }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{Channels: NewChannelPool(1, 10*time.Millisecond, tc.open)}
+			if tc.name == "exhausted" {
+				_, err := server.Channels.Get(context.Background())
+				require.NoError(t, err)
+			}
+
+			rr := httptest.NewRecorder()
+			_, ok := server.acquireChannel(rr, httptest.NewRequest(http.MethodPost, "/notify", nil))
+
+			assert.False(t, ok)
+			assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+			assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestCheckChannel(t *testing.T) {
+	server := NewServer(nil, nil, 0, nil, nil, nil)
+	assert.Error(t, server.checkChannel(context.Background()), "not ready before Start")
+
+	server.Channels = NewChannelPool(1, 10*time.Millisecond, func() (producer_types.PooledChannel, error) {
+		return &fakePooledChannel{}, nil
+	})
+	require.NoError(t, server.checkChannel(context.Background()))
+	require.NoError(t, server.checkChannel(context.Background()), "the borrowed channel is returned to the pool")
+
+	server.Channels = NewChannelPool(1, 10*time.Millisecond, func() (producer_types.PooledChannel, error) {
+		return nil, amqp.ErrClosed
+	})
+	assert.ErrorIs(t, server.checkChannel(context.Background()), amqp.ErrClosed)
+}
+
+func TestHandleNotification_RecipientRateLimit(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	server := &Server{Idempotency: store, IdempotencyWindow: time.Minute, RecipientLimit: ratelimit.NewKeyed(1.0/60, 1)}
+	mockCh := new(MockChannel)
+	mockCh.On("Close").Return(nil)
+	mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).Return(nil).Once()
+
+	send := func(body string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		server.handleNotification(rr, req, channelOf(mockCh))
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, send(`{"email":"a@b.com","message":"ok"}`, "first").Code)
+	rr := send(`{"to":["Someone <A@B.com>"],"message":"again"}`, "second")
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+	mockCh.AssertNumberOfCalls(t, "PublishWithContext", 1)
+	_, reserved, err := store.Reserve(context.Background(), "second", "", "next", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, reserved, "a rate limited request releases its idempotency key")
+}
+
+func TestPublishBatch_DomainRateLimit(t *testing.T) {
+	server := &Server{DomainLimit: ratelimit.NewKeyed(1, 1)}
+	mockCh := new(MockChannel)
+	mockCh.On("PublishWithContext", mock.Anything, "", constants.MainQueueName, true, false, mock.Anything).Return(nil)
+
+	resp := server.publishBatch(context.Background(), mockCh, []types.RequestBody{
+		{Email: "a@example.com", Message: "one"},
+		{Email: "b@example.com", Message: "two"},
+		{Email: "c@other.com", Message: "three"},
+	})
+
+	assert.Equal(t, 2, resp.Queued)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Contains(t, resp.Results[1].Error, "rate limit")
+}
+
+func TestLimitClient(t *testing.T) {
+	server := &Server{ClientLimit: ratelimit.NewKeyed(1, 1)}
+	handler := server.limitClient(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	call := func(req *http.Request) int {
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+	fromKey := func(id string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/notify", nil)
+		return req.WithContext(auth.WithKey(req.Context(), auth.Key{ID: id}))
+	}
+
+	assert.Equal(t, http.StatusOK, call(fromKey("key-1")))
+	assert.Equal(t, http.StatusTooManyRequests, call(fromKey("key-1")))
+	assert.Equal(t, http.StatusOK, call(fromKey("key-2")))
+	assert.Equal(t, http.StatusOK, call(httptest.NewRequest(http.MethodPost, "/notify", nil)), "anonymous clients are keyed by address")
+}
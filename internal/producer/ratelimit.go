@@ -0,0 +1,81 @@
+package producer
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+)
+
+func clientKey(req *http.Request) string {
+	if key, ok := auth.FromContext(req.Context()); ok {
+		return "key:" + key.ID
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func writeRateLimited(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// limitClient rate limits by API key, or by remote address when
+// authentication is disabled. It must run after auth.Require.
+func (s *Server) limitClient(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if ok, wait := s.ClientLimit.Allow(clientKey(req)); !ok {
+			writeRateLimited(w, "client rate limit exceeded", wait)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func normalizeAddress(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+func limitedRecipients(reqBody types.RequestBody) []string {
+	if reqBody.ChannelName() == types.ChannelSMS {
+		return []string{reqBody.Phone}
+	}
+	if reqBody.ChannelName() != types.ChannelEmail {
+		return nil
+	}
+	var recipients []string
+	for _, list := range [][]string{reqBody.Recipients(), reqBody.Cc, reqBody.Bcc} {
+		for _, address := range list {
+			recipients = append(recipients, normalizeAddress(address))
+		}
+	}
+	return recipients
+}
+
+// allowRecipients checks every recipient against the per-recipient limit and,
+// for email, its domain against the per-domain limit.
+func (s *Server) allowRecipients(reqBody types.RequestBody) (bool, time.Duration) {
+	for _, recipient := range limitedRecipients(reqBody) {
+		if ok, wait := s.RecipientLimit.Allow(recipient); !ok {
+			return false, wait
+		}
+		if at := strings.LastIndex(recipient, "@"); at >= 0 {
+			if ok, wait := s.DomainLimit.Allow(recipient[at+1:]); !ok {
+				return false, wait
+			}
+		}
+	}
+	return true, 0
+}
@@ -0,0 +1,73 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/schedule"
+	"github.com/jayanth-parthsarathy/notify/internal/common/status"
+	"github.com/jayanth-parthsarathy/notify/internal/common/tracing"
+	types "github.com/jayanth-parthsarathy/notify/internal/common/types"
+	producer_types "github.com/jayanth-parthsarathy/notify/internal/producer/types"
+)
+
+var errSchedulingDisabled = errors.New("send_at: scheduled delivery is not enabled")
+
+func (s *Server) scheduledAt(reqBody types.RequestBody) (time.Time, bool, error) {
+	sendAt, delayed, err := reqBody.ScheduledAt(time.Now())
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if delayed && s.Schedule == nil {
+		return time.Time{}, false, errSchedulingDisabled
+	}
+	return sendAt.UTC(), delayed, nil
+}
+
+func (s *Server) scheduleNotification(ctx context.Context, reqBody types.RequestBody, messageId string, sendAt time.Time) error {
+	reqBody.SendAt = nil
+	reqBody.Delay = ""
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	err = s.Schedule.Add(ctx, schedule.Notification{
+		MessageId: messageId,
+		Body:      jsonBody,
+		Headers:   auth.Stamp(ctx, tracing.Inject(ctx, nil)),
+		Owner:     owner(ctx),
+		SendAt:    sendAt,
+	})
+	if err != nil {
+		return err
+	}
+	s.trackStatus(ctx, messageId, status.Scheduled)
+	return nil
+}
+
+func writeScheduledResponse(w http.ResponseWriter, messageId string, sendAt time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(producer_types.NewScheduledResponse(messageId, sendAt))
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, req *http.Request) {
+	messageId := req.PathValue("id")
+	err := s.Schedule.Cancel(req.Context(), messageId, owner(req.Context()))
+	if errors.Is(err, schedule.ErrNotFound) {
+		http.Error(w, "Scheduled notification not found or already sent", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logs.LogError(err, "Failed to cancel scheduled notification")
+		http.Error(w, "could not cancel notification", http.StatusInternalServerError)
+		return
+	}
+	s.setStatus(req.Context(), messageId, status.Cancelled)
+	w.WriteHeader(http.StatusNoContent)
+}
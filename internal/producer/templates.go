@@ -0,0 +1,159 @@
+package producer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jayanth-parthsarathy/notify/internal/common/auth"
+	logs "github.com/jayanth-parthsarathy/notify/internal/common/log"
+	"github.com/jayanth-parthsarathy/notify/internal/common/templates"
+	producer_types "github.com/jayanth-parthsarathy/notify/internal/producer/types"
+)
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func decodeTemplate(w http.ResponseWriter, req *http.Request) (templates.Template, bool) {
+	var t templates.Template
+	err := json.NewDecoder(req.Body).Decode(&t)
+	if err != nil {
+		logs.LogError(err, "Invalid request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return t, false
+	}
+	defer req.Body.Close()
+	if t.Format == "" {
+		t.Format = templates.FormatText
+	}
+	if err := t.Validate(); err != nil {
+		http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+		return t, false
+	}
+	return t, true
+}
+
+func writeTemplateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, templates.ErrNotFound):
+		http.Error(w, "Template not found", http.StatusNotFound)
+	case errors.Is(err, templates.ErrExists):
+		http.Error(w, "Template already exists", http.StatusConflict)
+	case errors.Is(err, templates.ErrConflict):
+		http.Error(w, "Template was updated concurrently, retry", http.StatusConflict)
+	default:
+		logs.LogError(err, "Template store failed")
+		http.Error(w, "could not access templates", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleCreateTemplate(w http.ResponseWriter, req *http.Request) {
+	t, ok := decodeTemplate(w, req)
+	if !ok {
+		return
+	}
+	created, err := s.Templates.Create(req.Context(), t)
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleUpdateTemplate(w http.ResponseWriter, req *http.Request) {
+	t, ok := decodeTemplate(w, req)
+	if !ok {
+		return
+	}
+	if t.ID != req.PathValue("id") {
+		http.Error(w, "Template id does not match the URL", http.StatusBadRequest)
+		return
+	}
+	updated, err := s.Templates.Update(req.Context(), t)
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleListTemplates(w http.ResponseWriter, req *http.Request) {
+	list, err := s.Templates.List(req.Context())
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleGetTemplate(w http.ResponseWriter, req *http.Request) {
+	version := 0
+	if v := req.URL.Query().Get("version"); v != "" {
+		var err error
+		version, err = strconv.Atoi(v)
+		if err != nil || version < 1 {
+			http.Error(w, "version must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	t, err := s.Templates.Get(req.Context(), req.PathValue("id"), version)
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (s *Server) handleTemplateVersions(w http.ResponseWriter, req *http.Request) {
+	versions, err := s.Templates.Versions(req.Context(), req.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, req *http.Request) {
+	err := s.Templates.Delete(req.Context(), req.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePreviewTemplate(w http.ResponseWriter, req *http.Request) {
+	var preview producer_types.PreviewRequest
+	err := json.NewDecoder(req.Body).Decode(&preview)
+	if err != nil {
+		logs.LogError(err, "Invalid request body")
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	t, err := s.Templates.Get(req.Context(), req.PathValue("id"), preview.Version)
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	rendered, err := t.Render(preview.Data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, rendered)
+}
+
+func (s *Server) registerTemplateRoutes(mux *http.ServeMux) {
+	mux.Handle("POST /templates", s.Auth.RequireFunc(auth.ScopeTemplatesManage, s.handleCreateTemplate))
+	mux.Handle("GET /templates", s.Auth.RequireFunc(auth.ScopeTemplatesManage, s.handleListTemplates))
+	mux.Handle("GET /templates/{id}", s.Auth.RequireFunc(auth.ScopeTemplatesManage, s.handleGetTemplate))
+	mux.Handle("PUT /templates/{id}", s.Auth.RequireFunc(auth.ScopeTemplatesManage, s.handleUpdateTemplate))
+	mux.Handle("DELETE /templates/{id}", s.Auth.RequireFunc(auth.ScopeTemplatesManage, s.handleDeleteTemplate))
+	mux.Handle("GET /templates/{id}/versions", s.Auth.RequireFunc(auth.ScopeTemplatesManage, s.handleTemplateVersions))
+	mux.Handle("POST /templates/{id}/preview", s.Auth.RequireFunc(auth.ScopeTemplatesManage, s.handlePreviewTemplate))
+}
@@ -2,7 +2,12 @@ package producer_types
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jayanth-parthsarathy/notify/internal/common/connection"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -10,3 +15,60 @@ type Channel interface {
 	PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
 	Close() error
 }
+
+type PooledChannel interface {
+	Channel
+	IsClosed() bool
+}
+
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, key string, fingerprint string, messageId string, window time.Duration) (string, bool, error)
+	Release(ctx context.Context, key string) error
+}
+
+type DB interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type NotifyResponse struct {
+	MessageId string     `json:"message_id"`
+	Message   string     `json:"message"`
+	SendAt    *time.Time `json:"send_at,omitempty"`
+}
+
+type BatchResult struct {
+	Index     int        `json:"index"`
+	MessageId string     `json:"message_id,omitempty"`
+	SendAt    *time.Time `json:"send_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Queued    int           `json:"queued"`
+	Scheduled int           `json:"scheduled"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+func NewNotifyResponse(messageId string) NotifyResponse {
+	return NotifyResponse{MessageId: messageId, Message: "Notification queued successfully"}
+}
+
+func NewScheduledResponse(messageId string, sendAt time.Time) NotifyResponse {
+	return NotifyResponse{MessageId: messageId, Message: "Notification scheduled successfully", SendAt: &sendAt}
+}
+
+type PreviewRequest struct {
+	Version int            `json:"version"`
+	Data    map[string]any `json:"data"`
+}
+
+var (
+	ErrUnroutable     = connection.ErrUnroutable
+	ErrNacked         = connection.ErrNacked
+	ErrConfirmTimeout = connection.ErrConfirmTimeout
+	ErrPoolExhausted  = errors.New("no channel available in the pool")
+
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+)
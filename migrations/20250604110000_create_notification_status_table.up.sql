@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS notification_status (
+    message_id TEXT PRIMARY KEY,
+    status TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
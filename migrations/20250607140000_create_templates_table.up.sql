@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS templates (
+    id TEXT NOT NULL,
+    version INT NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    format TEXT NOT NULL DEFAULT 'text',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id, version)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS scheduled_notifications (
+    message_id TEXT PRIMARY KEY,
+    body JSONB NOT NULL,
+    send_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_notifications_send_at_idx ON scheduled_notifications (send_at);
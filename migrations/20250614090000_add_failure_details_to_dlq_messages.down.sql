@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS dlq_messages_error_class_idx;
+
+ALTER TABLE dlq_messages
+    DROP COLUMN IF EXISTS reason,
+    DROP COLUMN IF EXISTS error_class,
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS first_seen_at,
+    DROP COLUMN IF EXISTS original_queue;
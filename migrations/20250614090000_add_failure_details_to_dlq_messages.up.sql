@@ -0,0 +1,10 @@
+ALTER TABLE dlq_messages
+    ADD COLUMN reason TEXT NOT NULL DEFAULT '',
+    ADD COLUMN error_class TEXT NOT NULL DEFAULT '',
+    ADD COLUMN attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN first_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN original_queue TEXT NOT NULL DEFAULT '';
+
+UPDATE dlq_messages SET first_seen_at = received_at WHERE received_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS dlq_messages_error_class_idx ON dlq_messages (error_class);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS dlq_messages_search_idx;
+DROP INDEX IF EXISTS dlq_messages_received_at_idx;
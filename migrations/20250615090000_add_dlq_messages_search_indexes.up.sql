@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS dlq_messages_received_at_idx ON dlq_messages (received_at);
+
+CREATE INDEX IF NOT EXISTS dlq_messages_search_idx ON dlq_messages
+    USING GIN (to_tsvector('english', COALESCE(body->>'subject', '') || ' ' || COALESCE(body->>'message', '')));
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS dlq_requeue_audit (
+    id BIGSERIAL PRIMARY KEY,
+    message_id TEXT NOT NULL,
+    original_body JSONB NOT NULL,
+    edited_body JSONB,
+    exchange TEXT NOT NULL DEFAULT '',
+    routing_key TEXT NOT NULL,
+    requested_by TEXT NOT NULL DEFAULT '',
+    requeued_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS dlq_requeue_audit_message_id_idx ON dlq_requeue_audit (message_id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS dlq_messages_status_idx;
+DROP INDEX IF EXISTS dlq_messages_message_id_idx;
+
+ALTER TABLE dlq_messages
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS requeue_started_at,
+    DROP COLUMN IF EXISTS requeued_at;
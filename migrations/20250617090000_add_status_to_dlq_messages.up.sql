@@ -0,0 +1,8 @@
+ALTER TABLE dlq_messages
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'stored'
+        CONSTRAINT dlq_messages_status_check CHECK (status IN ('stored', 'requeuing', 'requeued')),
+    ADD COLUMN requeue_started_at TIMESTAMPTZ,
+    ADD COLUMN requeued_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS dlq_messages_message_id_idx ON dlq_messages (message_id, id DESC);
+CREATE INDEX IF NOT EXISTS dlq_messages_status_idx ON dlq_messages (status);
@@ -0,0 +1,5 @@
+ALTER TABLE scheduled_notifications
+    DROP COLUMN IF EXISTS owner,
+    DROP COLUMN IF EXISTS headers;
+
+ALTER TABLE notification_status DROP COLUMN IF EXISTS owner;
@@ -0,0 +1,5 @@
+ALTER TABLE notification_status ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE scheduled_notifications
+    ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}';